@@ -0,0 +1,245 @@
+package cgowrap
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// baseDirPlaceholder replaces the base directory in the stored stdout and
+// stderr.
+const baseDirPlaceholder = "${CGOWRAP_BASEDIR}"
+
+// BaseDir rewrites absolute paths under the directory given with
+// CGOWRAP_BASEDIR to be relative to the working directory, like ccache's
+// base_dir. It lets checkouts at different paths share the cache. The zero
+// value rewrites nothing.
+type BaseDir struct {
+	// Dir is the base directory.
+	Dir string
+	// rel is Dir relative to the working directory.
+	rel string
+	// real is Dir with its symlinks resolved, or an empty string if it
+	// doesn't change. The paths in keys are resolved, so it's rewritten too.
+	real string
+}
+
+// NewBaseDir creates a BaseDir for the given working directory. If the base
+// directory isn't an absolute path, then nothing is rewritten.
+func NewBaseDir(base, wd string) BaseDir {
+	if base == "" || !filepath.IsAbs(base) || !filepath.IsAbs(wd) {
+		return BaseDir{}
+	}
+
+	base = filepath.Clean(base)
+
+	rel, err := filepath.Rel(wd, base)
+	if err != nil {
+		return BaseDir{}
+	}
+
+	return BaseDir{Dir: base, rel: rel, real: realDir(base)}
+}
+
+// baseDirFromEnv returns the BaseDir given with CGOWRAP_BASEDIR for the current
+// working directory.
+func baseDirFromEnv() BaseDir {
+	wd, _ := os.Getwd()
+	return NewBaseDir(os.Getenv("CGOWRAP_BASEDIR"), wd)
+}
+
+// IsZero returns true if nothing is rewritten.
+func (b BaseDir) IsZero() bool {
+	return b.Dir == ""
+}
+
+// Rel rewrites every path under the base directory in the string, which may be
+// a flag with the path joined to it.
+func (b BaseDir) Rel(s string) string {
+	if b.IsZero() {
+		return s
+	}
+	s = replacePathPrefix(s, b.Dir, b.rel)
+	if b.real != "" {
+		s = replacePathPrefix(s, b.real, b.rel)
+	}
+	return s
+}
+
+// RelAll rewrites the paths in every string.
+func (b BaseDir) RelAll(strs []string) []string {
+	if b.IsZero() {
+		return strs
+	}
+
+	rel := make([]string, len(strs))
+	for i, s := range strs {
+		rel[i] = b.Rel(s)
+	}
+	return rel
+}
+
+// RelMaterial rewrites the paths in the key material, including the content of
+// the inputs, where cgo puts the paths of the Go files into #line directives.
+// A marker is added, so that the keys never equal those of the same material
+// without a base directory.
+func (b BaseDir) RelMaterial(material []interface{}) []interface{} {
+	if b.IsZero() {
+		return material
+	}
+
+	rel := make([]interface{}, 0, len(material)+1)
+	for _, v := range material {
+		switch v := v.(type) {
+		case string:
+			rel = append(rel, b.Rel(v))
+		case []string:
+			rel = append(rel, b.RelAll(v))
+		case []byte:
+			rel = append(rel, []byte(b.Rel(string(v))))
+		default:
+			rel = append(rel, v)
+		}
+	}
+
+	return append(rel, "basedir")
+}
+
+// RelocatableClassifier is implemented by Classifiers whose outputs may be
+// shared between checkouts. Keys are only rewritten with the base directory for
+// those, since the other outputs may contain the paths of the checkout that
+// made them, like in their debug information.
+type RelocatableClassifier interface {
+	Classifier
+	// Relocatable returns true if the outputs of the invocation don't
+	// contain the paths under the base directory, or if nothing reads them.
+	Relocatable(inv *Invocation, base string) bool
+}
+
+// Relocatable returns true if the outputs of the given kind of invocation may
+// be shared between checkouts under different base directories.
+func Relocatable(kind Classifier, inv *Invocation, base string) bool {
+	r, ok := kind.(RelocatableClassifier)
+	return ok && r.Relocatable(inv, base)
+}
+
+// prefixMapped returns true if the compiler flags map the directory to another
+// path both in the debug information and in __FILE__, either with
+// -ffile-prefix-map or with both -fdebug-prefix-map and -fmacro-prefix-map.
+func prefixMapped(args []string, dir string) bool {
+	var debug, macro bool
+
+	for _, arg := range args {
+		i := strings.IndexByte(arg, '=')
+		if i == -1 {
+			continue
+		}
+
+		old := arg[i+1:]
+		if j := strings.IndexByte(old, '='); j != -1 {
+			old = old[:j]
+		}
+		if !underDir(dir, old) {
+			continue
+		}
+
+		switch arg[:i] {
+		case "-ffile-prefix-map":
+			debug, macro = true, true
+		case "-fdebug-prefix-map":
+			debug = true
+		case "-fmacro-prefix-map":
+			macro = true
+		}
+	}
+
+	return debug && macro
+}
+
+// underDir returns true if the path is the directory or a path under it.
+func underDir(path, dir string) bool {
+	if dir == "" {
+		return false
+	}
+	dir = filepath.Clean(dir)
+	return path == dir || strings.HasPrefix(path, strings.TrimSuffix(dir, "/")+"/")
+}
+
+// stripOutput replaces the base directory in the output with a placeholder.
+func (b BaseDir) stripOutput(out []byte) []byte {
+	if b.IsZero() {
+		return out
+	}
+	return []byte(replacePathPrefix(string(out), b.Dir, baseDirPlaceholder))
+}
+
+// fillOutput replaces the placeholder in the output with the base directory.
+func (b BaseDir) fillOutput(out []byte) []byte {
+	if b.IsZero() {
+		return out
+	}
+	return bytes.ReplaceAll(out, []byte(baseDirPlaceholder), []byte(b.Dir))
+}
+
+// replacePathPrefix replaces every occurrence of the directory in s that's a
+// whole path or the start of one, as opposed to the end of a longer path.
+func replacePathPrefix(s, dir, with string) string {
+	if !strings.Contains(s, dir) {
+		return s
+	}
+
+	var b strings.Builder
+	b.Grow(len(s))
+
+	var last int
+	for start := 0; ; {
+		i := strings.Index(s[start:], dir)
+		if i == -1 {
+			b.WriteString(s[last:])
+			return b.String()
+		}
+		i += start
+
+		end := i + len(dir)
+		start = end
+
+		if end < len(s) && s[end] != filepath.Separator && isPathByte(s[end]) {
+			// Another directory that starts with the same name.
+			continue
+		}
+		if insidePath(s[:i]) {
+			// Another directory that ends with the same path.
+			continue
+		}
+
+		b.WriteString(s[last:i])
+		b.WriteString(with)
+		last = end
+	}
+}
+
+// insidePath returns true if the string ends inside a path, as opposed to at
+// the start of an argument or after a flag name that a path is joined to, like
+// -I.
+func insidePath(s string) bool {
+	for i := len(s) - 1; i >= 0; i-- {
+		switch {
+		case s[i] == filepath.Separator:
+			return true
+		case !isPathByte(s[i]):
+			return false
+		}
+	}
+	return false
+}
+
+// isPathByte returns true if the byte may continue a file name.
+func isPathByte(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	default:
+		return strings.IndexByte("._-+~@", c) >= 0
+	}
+}
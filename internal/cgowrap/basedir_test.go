@@ -0,0 +1,109 @@
+package cgowrap
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReplacePathPrefix(t *testing.T) {
+	tests := []struct {
+		s, expect string
+	}{
+		{"/a/repo", "X"},
+		{"/a/repo/x.c", "X/x.c"},
+		{"-I/a/repo/include", "-IX/include"},
+		{"/a/repo2/x.c", "/a/repo2/x.c"},
+		{"/a/repo.old/x.c", "/a/repo.old/x.c"},
+		{"/a/repo:1: error", "X:1: error"},
+		{"/a/repo2 /a/repo", "/a/repo2 X"},
+		{"/b/a/repo/x.c", "/b/a/repo/x.c"},
+		{"/b/a/repo /a/repo", "/b/a/repo X"},
+		{"/a/repo/a/repo", "X/a/repo"},
+		{"-ffile-prefix-map=/a/repo=.", "-ffile-prefix-map=X=."},
+	}
+
+	for _, test := range tests {
+		if got := replacePathPrefix(test.s, "/a/repo", "X"); got != test.expect {
+			t.Errorf("%q: expected %q, got %q", test.s, test.expect, got)
+		}
+	}
+}
+
+func TestBaseDir(t *testing.T) {
+	b := NewBaseDir("/a/repo", "/a/repo/pkg")
+
+	if got, expect := b.Rel("-I/a/repo/include"), "-I../include"; got != expect {
+		t.Errorf("expected %q, got %q", expect, got)
+	}
+	if got, expect := b.Rel("/a/repo2/include"), "/a/repo2/include"; got != expect {
+		t.Errorf("expected %q, got %q", expect, got)
+	}
+
+	out := []byte("/a/repo/pkg/x.c:1: error: /a/repo2/y.h not found")
+	stripped := b.stripOutput(out)
+	if expect := "${CGOWRAP_BASEDIR}/pkg/x.c:1: error: /a/repo2/y.h not found"; string(stripped) != expect {
+		t.Errorf("expected %q, got %q", expect, stripped)
+	}
+
+	other := NewBaseDir("/b/checkout", "/b/checkout/pkg")
+	if got, expect := other.fillOutput(stripped), "/b/checkout/pkg/x.c:1: error: /a/repo2/y.h not found"; string(got) != expect {
+		t.Errorf("expected %q, got %q", expect, got)
+	}
+
+	// Paths in keys have their symlinks resolved.
+	tmp := t.TempDir()
+	if err := os.Mkdir(filepath.Join(tmp, "real"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("real", filepath.Join(tmp, "repo")); err != nil {
+		t.Fatal(err)
+	}
+
+	linked := NewBaseDir(filepath.Join(tmp, "repo"), filepath.Join(tmp, "repo", "pkg"))
+	if got, expect := linked.Rel("-I"+filepath.Join(tmp, "real", "include")), "-I../include"; got != expect {
+		t.Errorf("expected %q, got %q", expect, got)
+	}
+}
+
+func TestPrefixMapped(t *testing.T) {
+	tests := []struct {
+		args   []string
+		mapped bool
+	}{
+		{nil, false},
+		{[]string{"-ffile-prefix-map=/a/repo=."}, true},
+		{[]string{"-ffile-prefix-map=/a=/b"}, true},
+		{[]string{"-ffile-prefix-map=/a/repo/=."}, true},
+		{[]string{"-ffile-prefix-map=/a/repo2=."}, false},
+		{[]string{"-ffile-prefix-map=/a/repo/sub=."}, false},
+		{[]string{"-fdebug-prefix-map=/a/repo=."}, false},
+		{[]string{"-fdebug-prefix-map=/a/repo=.", "-fmacro-prefix-map=/a/repo=."}, true},
+	}
+
+	for _, test := range tests {
+		if mapped := prefixMapped(test.args, "/a/repo"); mapped != test.mapped {
+			t.Errorf("%q: expected %v, got %v", test.args, test.mapped, mapped)
+		}
+	}
+}
+
+func TestCgoRunRelocatable(t *testing.T) {
+	tests := []struct {
+		args        []string
+		relocatable bool
+	}{
+		{[]string{"-objdir", "/w/b001/", "--", "-I", "/w/b001/", "a.go"}, false},
+		{[]string{"-trimpath", "/w/b001=>", "--", "a.go"}, false},
+		{[]string{"-trimpath", "/w/b001=>;/a/repo=>example.com/repo", "--", "a.go"}, true},
+		{[]string{"--trimpath=/a/repo=>example.com/repo", "--", "a.go"}, true},
+		{[]string{"--", "-trimpath", "/a/repo=>", "a.go"}, false},
+	}
+
+	for _, test := range tests {
+		run := CgoRun{Args: test.args}
+		if relocatable := run.Relocatable("/a/repo"); relocatable != test.relocatable {
+			t.Errorf("%q: expected %v, got %v", test.args, test.relocatable, relocatable)
+		}
+	}
+}
@@ -5,9 +5,11 @@ import (
 	"compress/zlib"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -25,6 +27,13 @@ var (
 var (
 	depfileBucket    = "depfile"
 	guessKindsBucket = "guessKinds"
+	dwarfBucket      = "dwarf"
+	macrosBucket     = "macros"
+	objectBucket     = "object"
+	linkBucket       = "link"
+	probeBucket      = "probe"
+	buildBucket      = "build"
+	compilerBucket   = "compiler"
 )
 
 func joinKeys(parts ...string) string {
@@ -75,9 +84,10 @@ func setKV(db *diskv.Diskv, keys []string, v []byte) error {
 }
 
 type Cache struct {
-	db         *diskv.Diskv
-	Depfile    *DepfileCache
-	GuessKinds *GuessKindsCache
+	db      *diskv.Diskv
+	base    BaseDir
+	work    WorkDirs
+	Depfile *DepfileCache
 }
 
 func OpenCache() (*Cache, error) {
@@ -92,21 +102,78 @@ func OpenCache() (*Cache, error) {
 		CacheSizeMax: 0,
 	})
 
-	c := &Cache{db: kv}
+	c := &Cache{db: kv, base: baseDirFromEnv()}
 	c.Depfile = (*DepfileCache)(c)
-	c.GuessKinds = (*GuessKindsCache)(c)
+
+	if err := c.migrate(); err != nil {
+		return nil, err
+	}
 
 	return c, nil
 }
 
+// SetWorkDirs sets the work directories of the invocation, which are stored as
+// placeholders. It must be called before Outputs.
+func (c *Cache) SetWorkDirs(work WorkDirs) {
+	c.work = work
+}
+
+// BaseDir returns the base directory given with CGOWRAP_BASEDIR.
+func (c *Cache) BaseDir() BaseDir {
+	return c.base
+}
+
+// DropBaseDir stops rewriting paths under the base directory, for invocations
+// whose outputs aren't Relocatable. It must be called before Outputs.
+func (c *Cache) DropBaseDir() {
+	c.base = BaseDir{}
+}
+
+// Outputs returns the OutputCache of the given bucket.
+func (c *Cache) Outputs(bucket string) *OutputCache {
+	return &OutputCache{db: c.db, base: c.base, work: c.work, bucket: bucket}
+}
+
+// KeyMaterial rewrites the paths in the key material in the same way that the
+// paths in the stored entries are rewritten.
+func (c *Cache) KeyMaterial(material []interface{}) []interface{} {
+	return c.base.RelMaterial(c.work.StripMaterial(material))
+}
+
+// storePath rewrites a path for storing. Paths under the base directory are
+// made relative, and the work directories are replaced with placeholders.
+func (c *DepfileCache) storePath(path string) string {
+	return c.base.Rel(c.work.Strip(path))
+}
+
+// loadPath rewrites a stored path back into a path that can be used.
+func (c *DepfileCache) loadPath(path string) string {
+	return c.work.Fill(path)
+}
+
 type DepfileCache Cache
 
+// hashDeps makes the depfile cache record the content hash of every dependency,
+// so that a dependency whose modification time changed but whose content
+// didn't is still valid.
+var hashDeps = os.Getenv("CGOWRAP_HASH_DEPS") == "1"
+
 type depfileValue struct {
-	File   depfile.File
-	Latest time.Time
+	File depfile.File
+	// Fingerprints maps every dependency of every target to its fingerprint.
+	Fingerprints map[string]depfile.Fingerprint
+	// Absent contains the paths that the compiler looked up headers at without
+	// finding them. They must stay absent.
+	Absent []string `json:",omitempty"`
+	// Saved is when the fingerprints were taken. Fingerprints that are racy
+	// against it are checked by their content.
+	Saved time.Time
 }
 
-// IsValid returns true if the depfile cache is still valid.
+// Validate returns nil if the depfile cache is still valid. The dependencies of
+// every target are checked separately against their own fingerprints. The
+// returned error wraps a *depfile.MissingError if a dependency is gone, or
+// ErrShadowed if a file was created where the compiler didn't find a header.
 func (c *DepfileCache) Validate(id string) error {
 	var value depfileValue
 
@@ -114,10 +181,51 @@ func (c *DepfileCache) Validate(id string) error {
 		return err
 	}
 
-	t := value.File.ModTime()
-	// Verify the file list's modification time.
-	if t.IsZero() || !t.Equal(value.Latest) {
-		return ErrMismatchModTime
+	for _, path := range value.Absent {
+		path = c.loadPath(path)
+		if _, err := os.Lstat(path); !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%s: %w", path, ErrShadowed)
+		}
+	}
+
+	start := time.Now()
+
+	var rehashed bool
+	var settled bool
+
+	for target, files := range value.File.Sources {
+		for _, file := range files {
+			fp, ok := value.Fingerprints[file]
+			if !ok {
+				return fmt.Errorf("%s: %s: %w", target, file, ErrMismatchModTime)
+			}
+
+			check := fp.Check
+			if fp.Racy(value.Saved) {
+				// The file may have changed within the same modification time.
+				check = fp.CheckContent
+				settled = settled || !fp.Racy(start)
+			}
+
+			now, err := check(c.loadPath(file))
+			if err != nil {
+				return fmt.Errorf("%s: %w", target, err)
+			}
+
+			if !now.ModTime.Equal(fp.ModTime) {
+				value.Fingerprints[file] = now
+				rehashed = true
+			}
+		}
+	}
+
+	if rehashed || settled {
+		// Remember the new modification times, so that the files aren't hashed
+		// again next time. Every racy file was just checked by its content, so
+		// the ones modified long enough ago aren't racy anymore.
+		value.Saved = start
+		err := c.saveValue(id, value)
+		logg.DebugFatalErr("cannot update fingerprints:", err)
 	}
 
 	return nil
@@ -127,19 +235,67 @@ func (c *DepfileCache) Path(id string) string {
 	return WorkFile("depfiles", id)
 }
 
-func (c *DepfileCache) Save(id string) error {
+// Save parses the depfile at Path and saves it. There must be a target for
+// every source, since a compiler that overwrites the depfile for each source
+// would otherwise leave out dependencies. The include search path is used to
+// record the lookups that found nothing.
+func (c *DepfileCache) Save(id string, dirs []string, sources ...string) error {
 	f, err := depfile.ParseFileOnDisk(c.Path(id))
 	if err != nil {
 		return err
 	}
 
-	// Get rid of the first input file.
-	f.PopFirstSources()
+	if len(f.Sources) < len(sources) {
+		return fmt.Errorf("depfile has %d targets for %d sources", len(f.Sources), len(sources))
+	}
 
-	v, err := json.Marshal(depfileValue{
-		File:   *f,
-		Latest: f.ModTime(),
-	})
+	// Get rid of the sources, since they're already part of the key.
+	f.OmitSources(sources...)
+
+	var files []string
+	for _, src := range f.Sources {
+		files = append(files, src...)
+	}
+
+	return c.SaveFile(id, f, AbsentPaths(files, dirs))
+}
+
+// SaveFile saves the given dependencies and the paths that must stay absent
+// directly instead of parsing them from the depfile at Path. Paths under the
+// base directory are saved relative to the working directory.
+func (c *DepfileCache) SaveFile(id string, f *depfile.File, absent []string) error {
+	value := depfileValue{
+		File:         depfile.File{Sources: make(map[string]depfile.FileList, len(f.Sources))},
+		Fingerprints: make(map[string]depfile.Fingerprint),
+		Absent:       mapStrs(absent, c.storePath),
+		Saved:        time.Now(),
+	}
+
+	for target, files := range f.Sources {
+		value.File.Sources[c.storePath(target)] = mapStrs(files, c.storePath)
+
+		for _, file := range files {
+			// Files in the work directories are written anew by every build,
+			// so only their content can tell if they changed.
+			fp, err := depfile.Stat(file, hashDeps || c.work.Contains(file))
+			if err == nil && fp.Hash == "" && fp.Racy(value.Saved) {
+				// The file was modified too recently for its modification
+				// time to tell if it changes again, so it must be checked
+				// by its content.
+				fp, err = depfile.Stat(file, true)
+			}
+			if err != nil {
+				return err
+			}
+			value.Fingerprints[c.storePath(file)] = fp
+		}
+	}
+
+	return c.saveValue(id, value)
+}
+
+func (c *DepfileCache) saveValue(id string, value depfileValue) error {
+	v, err := json.Marshal(value)
 	if err != nil {
 		return err
 	}
@@ -147,16 +303,56 @@ func (c *DepfileCache) Save(id string) error {
 	return setKV(c.db, []string{depfileBucket, id}, v)
 }
 
-type GuessKindsCache Cache
+// Files returns all dependencies saved for the given ID, along with the paths
+// that must stay absent.
+func (c *DepfileCache) Files(id string) (files depfile.FileList, absent []string, err error) {
+	var value depfileValue
+
+	if err := getKVJSON(c.db, []string{depfileBucket, id}, &value); err != nil {
+		return nil, nil, err
+	}
+
+	for _, src := range value.File.Sources {
+		files = append(files, mapStrs(src, c.loadPath)...)
+	}
+
+	return files, mapStrs(value.Absent, c.loadPath), nil
+}
 
+// OutputCache caches the Output of a compiler invocation inside its own
+// bucket.
+type OutputCache struct {
+	db     *diskv.Diskv
+	base   BaseDir
+	work   WorkDirs
+	io     IOPaths
+	bucket string
+}
+
+// SetIOPaths sets the paths of the input and output files of the invocation,
+// which are stored as placeholders.
+func (c *OutputCache) SetIOPaths(io IOPaths) {
+	c.io = io
+}
+
+// Output describes everything that a compiler invocation produces.
 type Output struct {
 	Stdout []byte `json:"-"`
 	Stderr []byte `json:"-"`
 	Status int    `json:"status"`
+	// Files maps an output flag, such as "-o", to the file that the compiler
+	// wrote into it.
+	Files map[string]File `json:"files,omitempty"`
+}
+
+// File describes an output file.
+type File struct {
+	Data []byte      `json:"-"`
+	Mode os.FileMode `json:"mode"`
 }
 
 func (o Output) IsEmpty() bool {
-	return len(o.Stdout) == 0 && len(o.Stderr) == 0
+	return len(o.Stdout) == 0 && len(o.Stderr) == 0 && len(o.Files) == 0
 }
 
 // Print prints the output.
@@ -165,33 +361,149 @@ func (o Output) Print() {
 	os.Stdout.Write(o.Stdout)
 }
 
-func (c *GuessKindsCache) Load(k string) (Output, bool) {
+// ReadFiles reads the files at the given paths into o.Files. The paths map
+// output flags to their paths on disk. Files that don't exist are skipped,
+// since the compiler doesn't write them if it fails. So are files that aren't
+// regular, like -o /dev/null, since they cannot be restored.
+func (o *Output) ReadFiles(paths map[string]string) error {
+	for name, path := range paths {
+		s, err := os.Stat(path)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return err
+		}
+
+		if !s.Mode().IsRegular() {
+			continue
+		}
+
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		if o.Files == nil {
+			o.Files = make(map[string]File, len(paths))
+		}
+		o.Files[name] = File{Data: b, Mode: s.Mode().Perm()}
+	}
+
+	return nil
+}
+
+// WriteFiles writes o.Files into the given paths, which map output flags to
+// their paths on disk. An error is returned if a file has no path.
+func (o Output) WriteFiles(paths map[string]string) error {
+	for name, file := range o.Files {
+		path, ok := paths[name]
+		if !ok {
+			return fmt.Errorf("no path for output file %q", name)
+		}
+
+		if err := writeFileAtomic(path, file.Data, file.Mode); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeFileAtomic writes the file into a temporary file next to path and
+// renames it over, so readers never see a half-written file.
+func writeFileAtomic(path string, b []byte, mode os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), ".cgowrap-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if _, err := f.Write(b); err != nil {
+		return err
+	}
+
+	if err := f.Chmod(mode); err != nil {
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
+
+// Load loads the Output saved under the given key. ErrKeyMismatch is returned
+// if the entry was saved with different key material.
+func (c *OutputCache) Load(key *Key) (Output, error) {
 	var out Output
-	keys := []string{guessKindsBucket, k, "json"}
+	k := key.ID()
+	keys := []string{c.bucket, k, "json"}
 
 	if err := getKVJSON(c.db, keys, &out); err != nil {
-		return out, false
+		return out, err
+	}
+
+	keys[2] = "key"
+	material, err := getKVCompressed(c.db, keys)
+	if err != nil {
+		return out, err
+	}
+	if !bytes.Equal(material, key.Material()) {
+		return out, fmt.Errorf("%s: %w", k, ErrKeyMismatch)
 	}
 
 	keys[2] = "out"
-	out.Stdout, _ = getKVCompressed(c.db, keys)
+	out.Stdout, err = getKVCompressed(c.db, keys)
+	if err != nil {
+		return out, err
+	}
 
 	keys[2] = "err"
-	out.Stderr, _ = getKVCompressed(c.db, keys)
+	out.Stderr, err = getKVCompressed(c.db, keys)
+	if err != nil {
+		return out, err
+	}
+
+	out.Stdout = c.fillOutput(out.Stdout)
+	out.Stderr = c.fillOutput(out.Stderr)
 
-	return out, !out.IsEmpty()
+	for name, file := range out.Files {
+		file.Data, err = getKVCompressed(c.db, []string{c.bucket, k, "file", name})
+		if err != nil {
+			return out, err
+		}
+		out.Files[name] = file
+	}
+
+	return out, nil
 }
 
-func (c *GuessKindsCache) Save(k string, out Output) error {
+// Save saves the Output under the given key, along with the key material that
+// Load checks.
+func (c *OutputCache) Save(key *Key, out Output) error {
 	j, err := json.Marshal(out)
 	if err != nil {
 		return err
 	}
 
+	k := key.ID()
+
+	// Other checkouts and builds fill in their own directories.
+	stdout := c.stripOutput(out.Stdout)
+	stderr := c.stripOutput(out.Stderr)
+
 	errs := []error{
-		setKV(c.db, []string{guessKindsBucket, k, "json"}, j),
-		setKV(c.db, []string{guessKindsBucket, k, "out"}, compressBytes(out.Stdout)),
-		setKV(c.db, []string{guessKindsBucket, k, "err"}, compressBytes(out.Stderr)),
+		setKV(c.db, []string{c.bucket, k, "key"}, compressBytes(key.Material())),
+		setKV(c.db, []string{c.bucket, k, "out"}, compressBytes(stdout)),
+		setKV(c.db, []string{c.bucket, k, "err"}, compressBytes(stderr)),
+	}
+
+	for name, file := range out.Files {
+		errs = append(errs,
+			setKV(c.db, []string{c.bucket, k, "file", name}, compressBytes(file.Data)))
 	}
 
 	for _, err := range errs {
@@ -199,7 +511,35 @@ func (c *GuessKindsCache) Save(k string, out Output) error {
 			return err
 		}
 	}
-	return nil
+
+	// Write the JSON last, since Load treats it as the marker of a complete
+	// entry.
+	return setKV(c.db, []string{c.bucket, k, "json"}, j)
+}
+
+// stripOutput replaces the input and output paths and the base and work
+// directories in the output with placeholders.
+func (c *OutputCache) stripOutput(out []byte) []byte {
+	if len(c.io.paths) > 0 {
+		out = []byte(c.io.Strip(string(out)))
+	}
+	if len(c.work.dirs) > 0 {
+		out = []byte(c.work.Strip(string(out)))
+	}
+	return c.base.stripOutput(out)
+}
+
+// fillOutput replaces the placeholders in the output with the current base and
+// work directories and input and output paths.
+func (c *OutputCache) fillOutput(out []byte) []byte {
+	out = c.base.fillOutput(out)
+	if len(c.work.dirs) > 0 {
+		out = []byte(c.work.Fill(string(out)))
+	}
+	if len(c.io.paths) > 0 {
+		out = []byte(c.io.Fill(string(out)))
+	}
+	return out
 }
 
 func compressBytes(b []byte) []byte {
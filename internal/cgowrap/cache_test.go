@@ -0,0 +1,69 @@
+package cgowrap
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+// openTestCache opens a cache inside a temporary root.
+func openTestCache(t *testing.T) *Cache {
+	t.Helper()
+
+	rootWorkDir = t.TempDir()
+	t.Cleanup(func() { rootWorkDir = "" })
+
+	c, err := OpenCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestOutputCacheKeyMismatch(t *testing.T) {
+	c := openTestCache(t)
+	outputs := c.Outputs(objectBucket)
+
+	ns := Namespace{GoVersion: "go1.99.0"}
+	key := NewKey(ns, "object", "-DA", "B")
+
+	out := Output{Stdout: []byte("out"), Stderr: []byte("err")}
+	if err := outputs.Save(key, out); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := outputs.Load(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(loaded.Stdout) != "out" || string(loaded.Stderr) != "err" {
+		t.Errorf("loaded %q and %q", loaded.Stdout, loaded.Stderr)
+	}
+
+	// An entry saved with different material under the same ID, as if the
+	// hashes collided.
+	other := NewKey(ns, "object", "-DAB")
+	err = setKV(c.db, []string{objectBucket, key.ID(), "key"}, compressBytes(other.Material()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := outputs.Load(key); !errors.Is(err, ErrKeyMismatch) {
+		t.Errorf("expected ErrKeyMismatch, got %v", err)
+	}
+}
+
+func TestReadFilesSkipsDevNull(t *testing.T) {
+	var out Output
+	if err := out.ReadFiles(map[string]string{"-o": os.DevNull}); err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Files) != 0 {
+		t.Errorf("expected no files, got %d", len(out.Files))
+	}
+
+	// Restoring must not try to replace it.
+	if err := out.WriteFiles(map[string]string{"-o": os.DevNull}); err != nil {
+		t.Fatal(err)
+	}
+}
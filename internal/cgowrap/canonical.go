@@ -0,0 +1,70 @@
+package cgowrap
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/diamondburned/cgowrap/internal/shortflag"
+)
+
+// pathValueFlags are the flags whose value is a path. The path is resolved in
+// the key, so that a symlinked directory gives the same key as the real one.
+var pathValueFlags = []string{
+	"-I", "-L", "-include", "-imacros", "-isystem", "-iquote", "-idirafter",
+	"-isystem-after", "-isysroot", "-iframework", "-F", "--sysroot",
+	"--include-directory", "--library-directory", "--include", "--imacros",
+}
+
+// randomSeedFlag is the flag that go build gives every compile, with a seed
+// that's derived from the build's action ID. The seed differs between builds of
+// the same sources, so only the presence of the flag is kept in keys.
+const randomSeedFlag = "-frandom-seed="
+
+// canonicalFlags returns the flags of the invocation in the order that they're
+// given, but each in a single spelling: -I foo and -Ifoo, -D X and -DX, and
+// --sysroot x and --sysroot=x are all the same. The name and the value of each
+// flag are separate strings, since joining them is ambiguous: -Ttext 0x1000 and
+// -T text0x1000 would both be -Ttext0x1000. The name decides whether a value
+// follows, so the list can only be read one way. The -o flag and the input
+// files are omitted, and so is the value of -frandom-seed.
+func canonicalFlags(inv *Invocation) ([]string, error) {
+	args, err := shortflag.Canonical(inv.Args, gccOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	flags := make([]string, 0, len(args))
+
+	for _, arg := range args {
+		if !arg.IsFlag() || arg.Name == "-o" {
+			continue
+		}
+
+		// -I- splits the search path instead of naming a directory.
+		if containsStrs(pathValueFlags, arg.Name) && arg.Value != "-" {
+			arg.Value = canonicalPath(inv.Dir, arg.Value)
+		}
+
+		if strings.HasPrefix(arg.Value, randomSeedFlag) {
+			arg.Value = randomSeedFlag
+		}
+
+		flags = append(flags, arg.Fields()...)
+	}
+
+	return flags, nil
+}
+
+// canonicalPath returns the path made absolute against dir with all symlinks
+// resolved. If the path doesn't exist, then it's only cleaned.
+func canonicalPath(dir, path string) string {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+
+	if real, err := filepath.EvalSymlinks(path); err == nil {
+		return real
+	}
+
+	return filepath.Clean(path)
+}
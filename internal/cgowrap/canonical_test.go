@@ -0,0 +1,84 @@
+package cgowrap
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCanonicalFlags(t *testing.T) {
+	flags := func(args ...string) []string {
+		inv := NewInvocation(CCDriver, append(args, "-c", "a.c"), "/", nil)
+
+		flags, err := canonicalFlags(inv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return flags
+	}
+
+	same := [][2][]string{
+		{{"-I", "/usr/include"}, {"-I/usr/include"}},
+		{{"-D", "X"}, {"-DX"}},
+		{{"--sysroot", "/"}, {"--sysroot=/"}},
+		{{"-o", "a.o"}, {"-o", "b.o"}},
+	}
+
+	for _, pair := range same {
+		if a, b := flags(pair[0]...), flags(pair[1]...); !reflect.DeepEqual(a, b) {
+			t.Errorf("%q and %q differ: %q and %q", pair[0], pair[1], a, b)
+		}
+	}
+
+	different := [][2][]string{
+		{{"-Ttext", "0x1000"}, {"-T", "text0x1000"}},
+		{{"-u", "ndef"}, {"-undef"}},
+		{{"-isystem-after", "foo"}, {"-isystem", "-afterfoo"}},
+		{{"-UX", "-DX"}, {"-DX", "-UX"}},
+	}
+
+	for _, pair := range different {
+		if a, b := flags(pair[0]...), flags(pair[1]...); reflect.DeepEqual(a, b) {
+			t.Errorf("%q and %q are the same: %q", pair[0], pair[1], a)
+		}
+	}
+}
+
+func TestCanonicalFlagsRandomSeed(t *testing.T) {
+	c := openTestCache(t)
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "_cgo_export.c"), []byte("int x;\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The argv that go build gives the compile of _cgo_export.c, in two
+	// builds of the same package.
+	key := func(work, seed string) string {
+		args := []string{
+			"-I", dir, "-fPIC", "-m64", "-pthread", "-fmessage-length=0",
+			"-ffile-prefix-map=" + work + "/b001=/tmp/go-build", "-gno-record-gcc-switches",
+			"-I", work + "/b001/", "-O2", "-g", "-ffile-prefix-map=" + dir + "=.",
+			"-frandom-seed=" + seed,
+			"-o", work + "/b001/_x001.o", "-c", "_cgo_export.c",
+		}
+		inv := NewInvocation(CCDriver, args, dir, nil)
+
+		dirs := FindWorkDirs(args...)
+		dirs.SetObjdir(inv.Output())
+		c.SetWorkDirs(dirs)
+
+		material, err := ObjectClassifier{}.Key(inv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return NewKey(Namespace{}, "object", c.KeyMaterial(material)...).ID()
+	}
+
+	a := key("/tmp/go-build1234", "1lx23sO9AamJ_F0yE7Yy")
+	b := key("/tmp/go-build5678", "Fq9eWJ3b0xHwRkVzP2cT")
+	if a != b {
+		t.Errorf("the builds have different keys %q and %q", a, b)
+	}
+}
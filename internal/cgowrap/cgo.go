@@ -0,0 +1,157 @@
+package cgowrap
+
+import (
+	"bytes"
+	"os"
+
+	"github.com/diamondburned/cgowrap/internal/shortflag"
+)
+
+// GuessKindsClassifier classifies cgo's guessKinds probe, which compiles a
+// crafted input and reads the errors to tell what each name refers to.
+type GuessKindsClassifier struct{}
+
+func (GuessKindsClassifier) Name() string   { return "guessKinds" }
+func (GuessKindsClassifier) Bucket() string { return guessKindsBucket }
+func (GuessKindsClassifier) Depfile() bool  { return true }
+
+// Match relies on the assumption that when the check passes, only one input
+// will ever be given, which is cgo's crafted input file.
+func (GuessKindsClassifier) Match(inv *Invocation) bool {
+	return bytesContainsLines(inv.Input(),
+		`#line 1 "cgo-generated-wrapper"`,
+		`#line 1 "completed"`,
+		`int __cgo__1 = __cgo__2;`,
+	)
+}
+
+func (GuessKindsClassifier) Key(inv *Invocation) ([]interface{}, error) {
+	return inputKey(inv)
+}
+
+func (GuessKindsClassifier) Outputs(inv *Invocation) map[string]string {
+	return outputFlag(inv)
+}
+
+func (GuessKindsClassifier) Template(inv *Invocation) string {
+	return cgoTemplate(inv.Input())
+}
+
+// Relocatable returns true, since cgo only reads the diagnostics, which have
+// the base directory replaced.
+func (GuessKindsClassifier) Relocatable(inv *Invocation, base string) bool { return true }
+
+func (GuessKindsClassifier) Normalize(inv *Invocation, out Output) Output {
+	return withoutTempObject(inv, out)
+}
+
+// DWARFClassifier classifies cgo's DWARF-loading compile step, which compiles
+// the input into an object file for cgo to read the DWARF from.
+type DWARFClassifier struct{}
+
+func (DWARFClassifier) Name() string   { return "dwarf" }
+func (DWARFClassifier) Bucket() string { return dwarfBucket }
+func (DWARFClassifier) Depfile() bool  { return true }
+
+func (DWARFClassifier) Match(inv *Invocation) bool {
+	return bytesContainsLines(inv.Input(),
+		`#line 1 "cgo-dwarf-inference"`,
+		`__cgodebug_ints[]`,
+		`__cgodebug_floats[]`,
+	)
+}
+
+func (DWARFClassifier) Key(inv *Invocation) ([]interface{}, error) {
+	return inputKey(inv)
+}
+
+func (DWARFClassifier) Outputs(inv *Invocation) map[string]string {
+	return outputFlag(inv)
+}
+
+func (DWARFClassifier) Template(inv *Invocation) string {
+	return cgoTemplate(inv.Input())
+}
+
+// Relocatable returns true, since cgo only reads the types and values out of
+// the object's debug information, not its paths.
+func (DWARFClassifier) Relocatable(inv *Invocation, base string) bool { return true }
+
+func (DWARFClassifier) Normalize(inv *Invocation, out Output) Output {
+	return withoutTempObject(inv, out)
+}
+
+// withoutTempObject leaves the output files out if cgo gave the input as a
+// temporary file, since its random path ends up in the object's debug
+// information.
+func withoutTempObject(inv *Invocation, out Output) Output {
+	if inv.InputName() != shortflag.Stdin {
+		out.Files = nil
+	}
+	return out
+}
+
+// MacrosClassifier classifies cgo's macro dump, which preprocesses the
+// preamble with -E -dM to collect its #defines.
+type MacrosClassifier struct{}
+
+func (MacrosClassifier) Name() string   { return "macros" }
+func (MacrosClassifier) Bucket() string { return macrosBucket }
+func (MacrosClassifier) Depfile() bool  { return true }
+
+func (MacrosClassifier) Match(inv *Invocation) bool {
+	return containsStrs(inv.Args, "-E", "-dM") && inv.Input() != nil
+}
+
+func (MacrosClassifier) Key(inv *Invocation) ([]interface{}, error) {
+	return inputKey(inv)
+}
+
+func (MacrosClassifier) Outputs(inv *Invocation) map[string]string {
+	return outputFlag(inv)
+}
+
+// Relocatable returns true, since the dumped macros don't name any file.
+func (MacrosClassifier) Relocatable(inv *Invocation, base string) bool { return true }
+
+func (MacrosClassifier) Template(inv *Invocation) string {
+	return cgoTemplate(inv.Input())
+}
+
+// inputKey returns the key material of an invocation: the canonical flags
+// without the -o flag, the working directory and the content of every input
+// file. The -o flag is not deterministic.
+func inputKey(inv *Invocation) ([]interface{}, error) {
+	flags, err := canonicalFlags(inv)
+	if err != nil {
+		return nil, err
+	}
+
+	material := []interface{}{flags, inv.Dir}
+
+	last := inv.InputName()
+	for _, name := range inv.InputNames() {
+		if name == last {
+			// This may be the standard input, which is buffered.
+			material = append(material, inv.Input())
+			continue
+		}
+
+		b, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		material = append(material, b)
+	}
+
+	return material, nil
+}
+
+func bytesContainsLines(b []byte, strs ...string) bool {
+	for _, str := range strs {
+		if !bytes.Contains(b, []byte(str)) {
+			return false
+		}
+	}
+	return true
+}
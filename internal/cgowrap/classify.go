@@ -0,0 +1,187 @@
+package cgowrap
+
+import (
+	"bytes"
+	"io"
+	"os"
+
+	"github.com/diamondburned/cgowrap/internal/shortflag"
+)
+
+// Invocation describes a single compiler invocation.
+type Invocation struct {
+	// Args contains the arguments given to the compiler.
+	Args []string
+	// Dir is the working directory of the compiler.
+	Dir string
+	// Driver is the compiler driver that's invoked.
+	Driver Driver
+
+	stdin     io.Reader
+	input     []byte
+	inputRead bool
+}
+
+// NewInvocation creates a new Invocation. The standard input is only read if
+// the compiler is given it as the input file.
+func NewInvocation(d Driver, args []string, dir string, stdin io.Reader) *Invocation {
+	return &Invocation{Args: args, Dir: dir, Driver: d, stdin: stdin}
+}
+
+// gccOpts parses the arguments of the compiler, so that flag values aren't
+// taken for input files.
+var gccOpts = shortflag.Opts{Table: shortflag.GCC}
+
+// InputNames returns all input files in the order that they're given. The
+// standard input is named "-".
+func (inv *Invocation) InputNames() []string {
+	// Canonical always returns the arguments that it has parsed.
+	args, _ := shortflag.Canonical(inv.Args, gccOpts)
+
+	var names []string
+	for _, arg := range args {
+		if !arg.IsFlag() {
+			names = append(names, arg.Value)
+		}
+	}
+
+	return names
+}
+
+// SourceNames returns the input files that are compiled, which are all but the
+// object files and libraries.
+func (inv *Invocation) SourceNames() []string {
+	var names []string
+	for _, name := range inv.InputNames() {
+		if !isObjectFile(name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// InputName returns the last input file, which is the only one that cgo and
+// go build give. If the standard input is the input, then "-" is returned.
+func (inv *Invocation) InputName() string {
+	names := inv.InputNames()
+	if len(names) == 0 {
+		return ""
+	}
+	return names[len(names)-1]
+}
+
+// Input returns the content of the file named by InputName, or nil if it cannot
+// be read. The file is only read once. The standard input is buffered whole, so
+// that it can still be given to the compiler with Stdin.
+func (inv *Invocation) Input() []byte {
+	if !inv.inputRead {
+		if name := inv.InputName(); name == shortflag.Stdin {
+			if inv.stdin != nil {
+				inv.input, _ = io.ReadAll(inv.stdin)
+			}
+		} else {
+			inv.input, _ = os.ReadFile(name)
+		}
+		inv.inputRead = true
+	}
+	return inv.input
+}
+
+// Stdin returns what the compiler should read as its standard input. It's the
+// buffered input if Input has consumed the standard input.
+func (inv *Invocation) Stdin() io.Reader {
+	if inv.inputRead && inv.InputName() == shortflag.Stdin {
+		return bytes.NewReader(inv.input)
+	}
+	return inv.stdin
+}
+
+// Output returns the path given with -o, or an empty string if there's none.
+func (inv *Invocation) Output() string {
+	f, err := shortflag.Parse(inv.Args, gccOpts)
+	if err != nil {
+		return ""
+	}
+
+	o := f.Flag("-o")
+	if o == nil || len(o.Values) == 0 {
+		return ""
+	}
+
+	return o.Values[len(o.Values)-1]
+}
+
+// Classifier recognizes a single kind of compiler invocation and describes how
+// it's cached.
+type Classifier interface {
+	// Name returns the name of the invocation kind.
+	Name() string
+	// Bucket returns the name of the bucket that the outputs are cached in.
+	Bucket() string
+	// Match returns true if the invocation is of this kind.
+	Match(inv *Invocation) bool
+	// Key returns the key material of the invocation. The -MD and -MF flags
+	// added for Depfile must not change it.
+	Key(inv *Invocation) ([]interface{}, error)
+	// Outputs returns the output files of the invocation as a map of names to
+	// paths. The names are what Output.Files is keyed with.
+	Outputs(inv *Invocation) map[string]string
+	// Depfile returns true if the key doesn't cover every input, so the
+	// dependencies must be tracked with a depfile and validated on every
+	// lookup.
+	Depfile() bool
+}
+
+var classifiers []Classifier
+
+// RegisterClassifier registers a Classifier. Classifiers are tried in the order
+// that they're registered, so more specific ones must come first.
+func RegisterClassifier(c Classifier) {
+	classifiers = append(classifiers, c)
+}
+
+// Classify returns the first Classifier that matches the invocation, or nil if
+// the invocation cannot be cached.
+func Classify(inv *Invocation) Classifier {
+	for _, c := range classifiers {
+		if c.Match(inv) {
+			return c
+		}
+	}
+	return nil
+}
+
+func init() {
+	// Probes and links are tried first, since they don't have an input file in
+	// the last argument. cgo's own invocations also compile C files into
+	// objects, so they must be tried before ObjectClassifier.
+	RegisterClassifier(ProbeClassifier{})
+	RegisterClassifier(LinkClassifier{})
+	RegisterClassifier(GuessKindsClassifier{})
+	RegisterClassifier(DWARFClassifier{})
+	RegisterClassifier(MacrosClassifier{})
+	RegisterClassifier(ObjectClassifier{})
+	RegisterClassifier(BuildClassifier{})
+}
+
+// outputFlag returns the outputs of an invocation whose only output file is
+// given with -o.
+func outputFlag(inv *Invocation) map[string]string {
+	if o := inv.Output(); o != "" {
+		return map[string]string{"-o": o}
+	}
+	return nil
+}
+
+func containsStrs(strv []string, strs ...string) bool {
+find:
+	for _, str := range strs {
+		for _, v := range strv {
+			if v == str {
+				continue find
+			}
+		}
+		return false
+	}
+	return true
+}
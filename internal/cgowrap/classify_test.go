@@ -0,0 +1,151 @@
+package cgowrap
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The inputs that cgo generates, cut down to the lines that tell them apart.
+const (
+	guessKindsInput = `#line 1 "cgo-builtin-prolog"
+#include <stddef.h>
+#line 1 "cgo-generated-wrapper"
+#include <stdio.h>
+#line 1 "not-declared"
+void __cgo_f_1_1(void) { __typeof__(puts) *__cgo_undefined__1; }
+#line 1 "not-type"
+void __cgo_f_1_2(void) { puts *__cgo_undefined__2; }
+#line 1 "completed"
+int __cgo__1 = __cgo__2;
+`
+	dwarfInput = `#line 1 "cgo-builtin-prolog"
+#include <stddef.h>
+#line 1 "cgo-generated-wrapper"
+#include <stdio.h>
+#line 1 "cgo-dwarf-inference"
+__typeof__(puts) *__cgo__1;
+long long __cgodebug_ints[] = {
+	0,
+	1
+};
+double __cgodebug_floats[] = {
+	0,
+	1
+};
+`
+)
+
+func TestClassify(t *testing.T) {
+	work := "/tmp/go-build1234"
+	link := "/tmp/go-link-5678"
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "_cgo_export.c"), []byte("int x;\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The flags that go build gives every compile of the package.
+	cflags := []string{
+		"-I", dir, "-fPIC", "-m64", "-pthread", "-fmessage-length=0",
+		"-ffile-prefix-map=" + work + "/b001=/tmp/go-build", "-gno-record-gcc-switches",
+	}
+	// The flags that cgo gives its own compiles.
+	cgoFlags := []string{
+		"-w", "-Wno-error", "-o", work + "/b001/_cgo_.o", "-gdwarf-2", "-c", "-xc", "-",
+		"-m64", "-fdiagnostics-color=never",
+	}
+
+	tests := []struct {
+		name   string
+		args   []string
+		stdin  string
+		expect string
+	}{
+		{
+			name:   "flag check",
+			args:   []string{"-Wl,--no-gc-sections", "-c", "-x", "c", "-", "-o", "/dev/null"},
+			expect: "probe",
+		},
+		{
+			name:   "version",
+			args:   []string{"--version"},
+			expect: "probe",
+		},
+		{
+			name:   "libgcc",
+			args:   []string{"-m64", "-print-libgcc-file-name"},
+			expect: "probe",
+		},
+		{
+			name: "dynimport link",
+			args: append(cflags,
+				"-o", work+"/b001/_cgo_.o", work+"/b001/_cgo_main.o",
+				work+"/b001/_x001.o", work+"/b001/_x002.o", "-O2", "-g"),
+			expect: "link",
+		},
+		{
+			name:   "guessKinds",
+			args:   cgoFlags,
+			stdin:  guessKindsInput,
+			expect: "guessKinds",
+		},
+		{
+			name:   "dwarf",
+			args:   cgoFlags,
+			stdin:  dwarfInput,
+			expect: "dwarf",
+		},
+		{
+			name:   "macros",
+			args:   []string{"-E", "-dM", "-xc", "-m64", "-"},
+			stdin:  "#include <stdio.h>\n",
+			expect: "macros",
+		},
+		{
+			name: "object",
+			args: append(cflags,
+				"-I", work+"/b001/", "-O2", "-g", "-frandom-seed=1lx23sO9AamJ_F0yE7Yy",
+				"-o", work+"/b001/_x001.o", "-c", filepath.Join(dir, "_cgo_export.c")),
+			expect: "object",
+		},
+		{
+			name:   "stdin compile that includes a header",
+			args:   []string{"-c", "-x", "c", "-", "-o", "/dev/null"},
+			stdin:  "#include <stdio.h>\n",
+			expect: "object",
+		},
+		{
+			name:   "linker flag check",
+			args:   []string{"-m64", "-o", link + "/a.out", "-no-pie", link + "/trivial.c"},
+			expect: "build",
+		},
+		{
+			name: "external link",
+			args: []string{
+				"-m64", "-o", link + "/a.out", "-rdynamic", link + "/go.o",
+				link + "/000000.o", link + "/000001.o", "-O2", "-g", "-lpthread", "-no-pie",
+			},
+		},
+		{
+			name: "preprocess",
+			args: []string{"-E", filepath.Join(dir, "_cgo_export.c")},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			inv := NewInvocation(CCDriver, test.args, dir, strings.NewReader(test.stdin))
+
+			var name string
+			if c := Classify(inv); c != nil {
+				name = c.Name()
+			}
+
+			if name != test.expect {
+				t.Errorf("expected %q, got %q", test.expect, name)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package cgowrap
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Driver is the compiler driver that cgowrap stands in for.
+type Driver string
+
+const (
+	// CCDriver stands in for CC, which also compiles Objective-C.
+	CCDriver Driver = "cc"
+	// CXXDriver stands in for CXX.
+	CXXDriver Driver = "c++"
+	// FCDriver stands in for FC.
+	FCDriver Driver = "fc"
+)
+
+// DriverFromName returns the driver that cgowrap stands in for when it's ran
+// under the given name, so that it can be linked to as cgowrap++ for CXX and
+// cgowrap-fc for FC. Names ending in "++" stand for CXX and names ending in
+// "fc" or "fortran" stand for FC, like the compilers' own names. Every other
+// name stands for CC.
+func DriverFromName(name string) Driver {
+	name = strings.TrimSuffix(filepath.Base(name), ".exe")
+
+	switch {
+	case strings.HasSuffix(name, "++"):
+		return CXXDriver
+	case strings.HasSuffix(name, "fc"), strings.HasSuffix(name, "fortran"):
+		return FCDriver
+	default:
+		return CCDriver
+	}
+}
+
+// Compiler returns the real compiler of the driver. CC is taken from
+// CGOWRAP_CC or GCC, CXX from CGOWRAP_CXX and FC from CGOWRAP_FC.
+func (d Driver) Compiler() string {
+	switch d {
+	case CXXDriver:
+		return envOr("CGOWRAP_CXX", "g++")
+	case FCDriver:
+		return envOr("CGOWRAP_FC", "gfortran")
+	default:
+		return envOr("CGOWRAP_CC", envOr("GCC", "gcc"))
+	}
+}
+
+// CC returns the real C compiler to run.
+func CC() string {
+	return CCDriver.Compiler()
+}
+
+func envOr(env, or string) string {
+	if v := os.Getenv(env); v != "" {
+		return v
+	}
+	return or
+}
+
+// Compiler describes the compiler binary that a driver resolves to.
+type Compiler struct {
+	// ID identifies the binary: its path, size and modification time after
+	// resolving symlinks, and the first line of its --version output.
+	ID string
+	// Family is the family of the compiler, which is guessed from its version.
+	Family Family
+}
+
+// Compiler returns the compiler binary that the driver resolves to. The
+// version is memoized per binary, so the compiler is only asked once.
+func (c *Cache) Compiler(d Driver) (Compiler, error) {
+	path, err := exec.LookPath(d.Compiler())
+	if err != nil {
+		return Compiler{}, err
+	}
+
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+
+	id, err := fileID(path)
+	if err != nil {
+		return Compiler{}, err
+	}
+
+	sum := sha256.Sum256([]byte(id))
+	keys := []string{compilerBucket, hex.EncodeToString(sum[:])}
+
+	version, err := getKVBytes(c.db, keys)
+	if err != nil {
+		version, err = compilerVersion(path)
+		if err != nil {
+			return Compiler{}, err
+		}
+		if err := setKV(c.db, keys, version); err != nil {
+			return Compiler{}, err
+		}
+	}
+
+	return Compiler{
+		ID:     id + " " + string(version),
+		Family: familyFromVersion(string(version)),
+	}, nil
+}
+
+// compilerVersion returns the first line of the compiler's --version output.
+func compilerVersion(path string) ([]byte, error) {
+	out, err := exec.Command(path, "--version").Output()
+	if err != nil {
+		return nil, err
+	}
+
+	line, _, _ := bufio.NewReader(bytes.NewReader(out)).ReadLine()
+	return line, nil
+}
@@ -0,0 +1,93 @@
+package cgowrap
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDriverFromName(t *testing.T) {
+	tests := map[string]Driver{
+		"cgowrap":                     CCDriver,
+		"/usr/local/bin/cgowrap":      CCDriver,
+		"cgowrap++":                   CXXDriver,
+		"cgowrap-fc":                  FCDriver,
+		"gcc":                         CCDriver,
+		"x86_64-linux-gnu-gcc":        CCDriver,
+		"x86_64-linux-gnu-g++":        CXXDriver,
+		"clang++":                     CXXDriver,
+		"gfortran":                    FCDriver,
+		"x86_64-w64-mingw32-gfortran": FCDriver,
+		"cgowrap++.exe":               CXXDriver,
+	}
+
+	for name, expect := range tests {
+		if d := DriverFromName(name); d != expect {
+			t.Errorf("%s: expected %q, got %q", name, expect, d)
+		}
+	}
+}
+
+func TestCacheCompiler(t *testing.T) {
+	c := openTestCache(t)
+
+	dir := t.TempDir()
+	runs := filepath.Join(dir, "runs")
+
+	// writeCC writes a compiler that prints the given version and counts how
+	// often it's asked.
+	writeCC := func(name, version string) string {
+		path := filepath.Join(dir, name)
+		script := "#!/bin/sh\necho run >> " + runs + "\necho '" + version + "'\necho 'Copyright'\n"
+		if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	compiler := func(path string) Compiler {
+		t.Setenv("CGOWRAP_CC", path)
+		cc, err := c.Compiler(CCDriver)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cc
+	}
+
+	gcc := compiler(writeCC("gcc", "gcc (Debian 12.2.0-14) 12.2.0"))
+	if !strings.HasSuffix(gcc.ID, " gcc (Debian 12.2.0-14) 12.2.0") || gcc.Family != GCCFamily {
+		t.Errorf("unexpected compiler %+v", gcc)
+	}
+
+	if again := compiler(filepath.Join(dir, "gcc")); again != gcc {
+		t.Errorf("the same compiler changed from %+v to %+v", gcc, again)
+	}
+	if b, _ := os.ReadFile(runs); strings.Count(string(b), "run") != 1 {
+		t.Errorf("the version was asked for %d times", strings.Count(string(b), "run"))
+	}
+
+	// A symlink is the binary that it links to.
+	if err := os.Symlink("gcc", filepath.Join(dir, "cc")); err != nil {
+		t.Fatal(err)
+	}
+	if linked := compiler(filepath.Join(dir, "cc")); linked != gcc {
+		t.Errorf("the symlinked compiler changed from %+v to %+v", gcc, linked)
+	}
+
+	clang := compiler(writeCC("clang", "Debian clang version 14.0.6"))
+	if clang.ID == gcc.ID || clang.Family != ClangFamily {
+		t.Errorf("unexpected compiler %+v", clang)
+	}
+
+	// An upgrade replaces the binary and its version.
+	path := writeCC("gcc", "gcc (Debian 12.3.0-1) 12.3.0")
+	later := time.Now().Add(time.Second)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if upgraded := compiler(path); !strings.HasSuffix(upgraded.ID, " 12.3.0") {
+		t.Errorf("the upgraded compiler has the old ID %q", upgraded.ID)
+	}
+}
@@ -0,0 +1,95 @@
+package cgowrap
+
+import (
+	"os"
+	"strings"
+)
+
+// Family is a family of compilers that read the same environment variables.
+type Family string
+
+const (
+	GCCFamily   Family = "gcc"
+	ClangFamily Family = "clang"
+)
+
+// familyFromVersion guesses the compiler family from the first line of the
+// compiler's --version output. Compilers that aren't clang are taken to be gcc.
+func familyFromVersion(version string) Family {
+	if strings.Contains(strings.ToLower(version), "clang") {
+		return ClangFamily
+	}
+	return GCCFamily
+}
+
+// localeEnv are the locale variables, which change the language of the
+// compiler's messages.
+var localeEnv = []string{"LANG", "LC_ALL", "LC_CTYPE", "LC_MESSAGES"}
+
+// familyEnv maps each compiler family to the environment variables that change
+// what the compiler produces.
+var familyEnv = map[Family][]string{
+	GCCFamily: append([]string{
+		"CPATH",
+		"C_INCLUDE_PATH",
+		"CPLUS_INCLUDE_PATH",
+		"OBJC_INCLUDE_PATH",
+		"LIBRARY_PATH",
+		"GCC_EXEC_PREFIX",
+		"COMPILER_PATH",
+		"SOURCE_DATE_EPOCH",
+		"GCC_COLORS",
+	}, localeEnv...),
+	ClangFamily: append([]string{
+		"CPATH",
+		"C_INCLUDE_PATH",
+		"CPLUS_INCLUDE_PATH",
+		"OBJC_INCLUDE_PATH",
+		"OBJCPLUS_INCLUDE_PATH",
+		"LIBRARY_PATH",
+		"COMPILER_PATH",
+		"SOURCE_DATE_EPOCH",
+		"SDKROOT",
+		"MACOSX_DEPLOYMENT_TARGET",
+	}, localeEnv...),
+}
+
+// EnvNames returns the names of the environment variables that are part of the
+// key. CGOWRAP_ENV replaces the family's defaults with a comma-separated list
+// of names, and CGOWRAP_EXTRA_ENV adds to them.
+func (c Compiler) EnvNames() []string {
+	var names []string
+	if env, ok := os.LookupEnv("CGOWRAP_ENV"); ok {
+		names = splitEnvNames(env)
+	} else {
+		names = familyEnv[c.Family]
+	}
+	return append(names[:len(names):len(names)], splitEnvNames(os.Getenv("CGOWRAP_EXTRA_ENV"))...)
+}
+
+// Env returns the key material of the environment variables in EnvNames. Unset
+// variables are told apart from empty ones.
+func (c Compiler) Env() []string {
+	names := c.EnvNames()
+	env := make([]string, len(names))
+
+	for i, name := range names {
+		if v, ok := os.LookupEnv(name); ok {
+			env[i] = name + "=" + v
+		} else {
+			env[i] = name
+		}
+	}
+
+	return env
+}
+
+func splitEnvNames(list string) []string {
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,53 @@
+package cgowrap
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestCompilerEnv(t *testing.T) {
+	// Setenv restores the variables after the test.
+	for _, name := range []string{"CGOWRAP_ENV", "CGOWRAP_EXTRA_ENV", "BAR"} {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+
+	gcc := Compiler{Family: GCCFamily}
+	clang := Compiler{Family: ClangFamily}
+
+	if names := gcc.EnvNames(); !reflect.DeepEqual(familyEnv[GCCFamily], names) {
+		t.Errorf("expected gcc's variables, got %q", names)
+	}
+	if names := clang.EnvNames(); !containsStrs(names, "SDKROOT") || containsStrs(names, "GCC_EXEC_PREFIX") {
+		t.Errorf("expected clang's variables, got %q", names)
+	}
+
+	t.Setenv("CGOWRAP_EXTRA_ENV", "FOO, BAR")
+	expect := append(familyEnv[GCCFamily][:len(familyEnv[GCCFamily]):len(familyEnv[GCCFamily])], "FOO", "BAR")
+	if names := gcc.EnvNames(); !reflect.DeepEqual(expect, names) {
+		t.Errorf("expected %q, got %q", expect, names)
+	}
+
+	t.Setenv("CGOWRAP_ENV", "CPATH,,LANG")
+	if names := gcc.EnvNames(); !reflect.DeepEqual([]string{"CPATH", "LANG", "FOO", "BAR"}, names) {
+		t.Errorf("expected CGOWRAP_ENV and CGOWRAP_EXTRA_ENV, got %q", names)
+	}
+
+	// An empty CGOWRAP_ENV leaves out every default.
+	t.Setenv("CGOWRAP_ENV", "")
+	if names := gcc.EnvNames(); !reflect.DeepEqual([]string{"FOO", "BAR"}, names) {
+		t.Errorf("expected only CGOWRAP_EXTRA_ENV, got %q", names)
+	}
+
+	t.Setenv("FOO", "")
+	os.Unsetenv("BAR")
+	if env := gcc.Env(); !reflect.DeepEqual([]string{"FOO=", "BAR"}, env) {
+		t.Errorf("expected empty and unset variables, got %q", env)
+	}
+
+	// The defaults must not be changed by CGOWRAP_EXTRA_ENV.
+	if containsStrs(familyEnv[GCCFamily], "FOO") {
+		t.Error("CGOWRAP_EXTRA_ENV changed the defaults")
+	}
+}
@@ -0,0 +1,121 @@
+package cgowrap
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// IOPaths replaces the paths of the input and output files of an invocation in
+// its stdout and stderr with placeholders. Diagnostics name the files that the
+// compiler was given, which may be temporary, so the stored output only ever
+// contains the placeholders, which are filled with the paths of the invocation
+// that replays it. The zero value replaces nothing.
+type IOPaths struct {
+	paths        []string
+	placeholders []string
+}
+
+// NewIOPaths creates IOPaths for the given input names and output paths, which
+// map output names to paths like Classifier.Outputs. The standard input has no
+// path, so it's skipped.
+func NewIOPaths(inputs []string, outputs map[string]string) IOPaths {
+	var p IOPaths
+
+	var n int
+	for _, input := range inputs {
+		if input != "-" {
+			p.add(input, ioPlaceholder("INPUT", n))
+			n++
+		}
+	}
+
+	names := make([]string, 0, len(outputs))
+	for name := range outputs {
+		names = append(names, name)
+	}
+	// The placeholders must be the same for every invocation with the same key.
+	sort.Strings(names)
+
+	for i, name := range names {
+		p.add(outputs[name], ioPlaceholder("OUTPUT", i))
+	}
+
+	return p
+}
+
+func (p *IOPaths) add(path, placeholder string) {
+	if path == "" || containsStrs(p.paths, path) {
+		return
+	}
+	p.paths = append(p.paths, path)
+	p.placeholders = append(p.placeholders, placeholder)
+}
+
+func ioPlaceholder(name string, i int) string {
+	if i == 0 {
+		return "${" + name + "}"
+	}
+	return fmt.Sprintf("${%s%d}", name, i+1)
+}
+
+// Strip replaces the paths in s with their placeholders. Longer paths are
+// replaced first, so that a path that ends another one isn't replaced inside
+// it.
+func (p IOPaths) Strip(s string) string {
+	order := make([]int, len(p.paths))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return len(p.paths[order[i]]) > len(p.paths[order[j]])
+	})
+
+	for _, i := range order {
+		s = replacePath(s, p.paths[i], p.placeholders[i])
+	}
+	return s
+}
+
+// Fill replaces the placeholders in s with the paths.
+func (p IOPaths) Fill(s string) string {
+	for i, path := range p.paths {
+		s = strings.ReplaceAll(s, p.placeholders[i], path)
+	}
+	return s
+}
+
+// replacePath replaces every occurrence of the path in s that's a whole path,
+// as opposed to a part of a longer file name or path.
+func replacePath(s, path, with string) string {
+	if !strings.Contains(s, path) {
+		return s
+	}
+
+	var b strings.Builder
+	b.Grow(len(s))
+
+	var last int
+	for start := 0; ; {
+		i := strings.Index(s[start:], path)
+		if i == -1 {
+			b.WriteString(s[last:])
+			return b.String()
+		}
+		i += start
+
+		end := i + len(path)
+		start = end
+
+		if end < len(s) && isPathByte(s[end]) {
+			continue
+		}
+		if i > 0 && (isPathByte(s[i-1]) || s[i-1] == '/') {
+			continue
+		}
+
+		b.WriteString(s[last:i])
+		b.WriteString(with)
+		last = end
+	}
+}
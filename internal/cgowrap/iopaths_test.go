@@ -0,0 +1,58 @@
+package cgowrap
+
+import "testing"
+
+func TestReplacePath(t *testing.T) {
+	tests := []struct {
+		s, path, expect string
+	}{
+		{"a.c:1: error", "a.c", "X:1: error"},
+		{"/tmp/a.c:1: error", "/tmp/a.c", "X:1: error"},
+		{"In file included from /tmp/a.c:1:", "/tmp/a.c", "In file included from X:1:"},
+		{"'/tmp/a.c' and \"/tmp/a.c\"", "/tmp/a.c", "'X' and \"X\""},
+		{"/tmp/a.cc:1: error", "/tmp/a.c", "/tmp/a.cc:1: error"},
+		{"/tmp/ba.c:1: error", "a.c", "/tmp/ba.c:1: error"},
+		{"/tmp/a.c:1: error", "a.c", "/tmp/a.c:1: error"},
+		{"/x/tmp/a.c", "/tmp/a.c", "/x/tmp/a.c"},
+		{"a.c a.c", "a.c", "X X"},
+		{"no paths", "a.c", "no paths"},
+	}
+
+	for _, test := range tests {
+		if got := replacePath(test.s, test.path, "X"); got != test.expect {
+			t.Errorf("replacePath(%q, %q): expected %q, got %q", test.s, test.path, test.expect, got)
+		}
+	}
+}
+
+func TestIOPaths(t *testing.T) {
+	stored := NewIOPaths(
+		[]string{"/tmp/cgo-gcc-input-1.c", "-"},
+		map[string]string{"-o": "/tmp/go-build1/b001/_x001.o"},
+	)
+	current := NewIOPaths(
+		[]string{"/tmp/cgo-gcc-input-22.c", "-"},
+		map[string]string{"-o": "/tmp/go-build2/b001/_x001.o"},
+	)
+
+	in := "/tmp/cgo-gcc-input-1.c:3:1: error: cannot write /tmp/go-build1/b001/_x001.o\n"
+	expect := "/tmp/cgo-gcc-input-22.c:3:1: error: cannot write /tmp/go-build2/b001/_x001.o\n"
+
+	stripped := stored.Strip(in)
+	if stripped != "${INPUT}:3:1: error: cannot write ${OUTPUT}\n" {
+		t.Errorf("unexpected stripped output %q", stripped)
+	}
+
+	if got := current.Fill(stripped); got != expect {
+		t.Errorf("expected %q, got %q", expect, got)
+	}
+
+	// A path that ends another one must not be replaced inside it.
+	p := NewIOPaths([]string{"a.c", "/src/a.c"}, nil)
+	if got := p.Strip("/src/a.c a.c"); got != "${INPUT2} ${INPUT}" {
+		t.Errorf("unexpected stripped output %q", got)
+	}
+	if got := p.Fill(p.Strip("/src/a.c a.c")); got != "/src/a.c a.c" {
+		t.Errorf("round trip gives %q", got)
+	}
+}
@@ -0,0 +1,115 @@
+package cgowrap
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// ErrKeyMismatch is returned if a cache entry was saved with different key
+// material than what it's loaded with, which means that the hashes collided
+// or that the key is computed wrongly.
+var ErrKeyMismatch = errors.New("key material mismatch")
+
+// The type tags of the fields in the key material. The digest tags are the
+// tags of the fields that are encoded as their SHA-256 sum.
+const (
+	keyString       = 's'
+	keyStringDigest = 'S'
+	keyBytesDigest  = 'b'
+	keyStrings      = 'l'
+	keyInt          = 'i'
+	keyOther        = 'v'
+	keyOtherDigest  = 'V'
+)
+
+// maxKeyField is the length of the longest string field that's encoded as it
+// is. Longer ones are encoded as their digest, which keeps the stored material
+// small.
+const maxKeyField = 1024
+
+// Key is the key of a cache entry. Its material is encoded as typed,
+// length-prefixed fields, so that different material never encodes the same,
+// like ["-DA", "B"] and ["-DAB"].
+type Key struct {
+	name     string
+	ns       Namespace
+	material []byte
+}
+
+// NewKey creates a new Key for the given invocation kind in the namespace out
+// of the key material. Strings, string slices and ints are encoded as they
+// are, and anything else is formatted with fmt. Byte slices, which are the
+// contents of files, and strings longer than maxKeyField are encoded as their
+// digest. The namespace is part of the material.
+func NewKey(ns Namespace, name string, material ...interface{}) *Key {
+	var buf bytes.Buffer
+	encodeKeyField(&buf, ns.GoVersion)
+	encodeKeyField(&buf, ns.Template)
+	for _, v := range material {
+		encodeKeyField(&buf, v)
+	}
+	return &Key{name: name, ns: ns, material: buf.Bytes()}
+}
+
+func encodeKeyField(buf *bytes.Buffer, v interface{}) {
+	switch v := v.(type) {
+	case string:
+		writeKeyString(buf, keyString, keyStringDigest, v)
+	case []byte:
+		sum := sha256.Sum256(v)
+		writeKeyField(buf, keyBytesDigest, sum[:])
+	case []string:
+		buf.WriteByte(keyStrings)
+		writeUvarint(buf, uint64(len(v)))
+		for _, str := range v {
+			writeKeyString(buf, keyString, keyStringDigest, str)
+		}
+	case int:
+		buf.WriteByte(keyInt)
+		var b [binary.MaxVarintLen64]byte
+		buf.Write(b[:binary.PutVarint(b[:], int64(v))])
+	default:
+		writeKeyString(buf, keyOther, keyOtherDigest, fmt.Sprint(v))
+	}
+}
+
+func writeKeyString(buf *bytes.Buffer, tag, digestTag byte, s string) {
+	if len(s) > maxKeyField {
+		sum := sha256.Sum256([]byte(s))
+		writeKeyField(buf, digestTag, sum[:])
+		return
+	}
+	writeKeyField(buf, tag, []byte(s))
+}
+
+func writeKeyField(buf *bytes.Buffer, tag byte, b []byte) {
+	buf.WriteByte(tag)
+	writeUvarint(buf, uint64(len(b)))
+	buf.Write(b)
+}
+
+func writeUvarint(buf *bytes.Buffer, v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	buf.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+// Hash returns the hash of the key material.
+func (k *Key) Hash() string {
+	sum := sha256.Sum256(k.material)
+	return base64.URLEncoding.EncodeToString(sum[:])
+}
+
+// ID returns the ID that the entry is stored under, which is the name of the
+// invocation kind, the namespace and the hash.
+func (k *Key) ID() string {
+	return k.name + "." + k.ns.ID() + "." + k.Hash()
+}
+
+// Material returns the encoded key material.
+func (k *Key) Material() []byte {
+	return k.material
+}
@@ -0,0 +1,56 @@
+package cgowrap
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestNewKey(t *testing.T) {
+	ns := Namespace{GoVersion: "go1.99.0"}
+
+	// Each pair of material must give different keys.
+	tests := []struct {
+		name string
+		a, b []interface{}
+	}{
+		{"joined flags", []interface{}{[]string{"-DA", "B"}}, []interface{}{[]string{"-DAB"}}},
+		{"split strings", []interface{}{"-DA", "B"}, []interface{}{"-DAB"}},
+		{"list and strings", []interface{}{[]string{"a", "b"}}, []interface{}{"a", "b"}},
+		{"string and bytes", []interface{}{"a"}, []interface{}{[]byte("a")}},
+		{"int and string", []interface{}{1}, []interface{}{"1"}},
+		{"empty list", []interface{}{[]string{}}, []interface{}{[]string{""}}},
+		{"order", []interface{}{"a", "b"}, []interface{}{"b", "a"}},
+		{"bytes", []interface{}{[]byte("a")}, []interface{}{[]byte("b")}},
+		{"long strings", []interface{}{strings.Repeat("a", 2000)}, []interface{}{strings.Repeat("a", 2001)}},
+		{"long string and bytes", []interface{}{strings.Repeat("a", 2000)}, []interface{}{[]byte(strings.Repeat("a", 2000))}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a := NewKey(ns, "object", test.a...)
+			b := NewKey(ns, "object", test.b...)
+			if a.Hash() == b.Hash() {
+				t.Errorf("%q and %q have the same hash", test.a, test.b)
+			}
+		})
+	}
+
+	a := NewKey(ns, "object", "a", []string{"b"}, []byte("c"), 1)
+	b := NewKey(ns, "object", "a", []string{"b"}, []byte("c"), 1)
+	if a.ID() != b.ID() {
+		t.Errorf("the same material has different IDs %q and %q", a.ID(), b.ID())
+	}
+
+	c := NewKey(Namespace{GoVersion: "go1.98.0"}, "object", "a", []string{"b"}, []byte("c"), 1)
+	if a.ID() == c.ID() {
+		t.Errorf("different namespaces have the same ID %q", a.ID())
+	}
+
+	// File contents and long strings are stored as their digests.
+	big := bytes.Repeat([]byte("x"), 1<<20)
+	d := NewKey(ns, "object", big, string(big), []string{string(big)})
+	if n := len(d.Material()); n > 1024 {
+		t.Errorf("the material of 3 MiB of fields is %d bytes", n)
+	}
+}
@@ -0,0 +1,96 @@
+package cgowrap
+
+import (
+	"path/filepath"
+
+	"github.com/diamondburned/cgowrap/internal/shortflag"
+)
+
+// languageExts maps file extensions to the language names that -x takes, in
+// the same way that gcc guesses the language of a file.
+var languageExts = map[string]string{
+	".c":   "c",
+	".i":   "cpp-output",
+	".h":   "c-header",
+	".cc":  "c++",
+	".cp":  "c++",
+	".cxx": "c++",
+	".cpp": "c++",
+	".CPP": "c++",
+	".c++": "c++",
+	".C":   "c++",
+	".ii":  "c++-cpp-output",
+	".hh":  "c++-header",
+	".hpp": "c++-header",
+	".hxx": "c++-header",
+	".m":   "objective-c",
+	".mi":  "objective-c-cpp-output",
+	".mm":  "objective-c++",
+	".M":   "objective-c++",
+	".f":   "f77",
+	".for": "f77",
+	".ftn": "f77",
+	".F":   "f77-cpp-input",
+	".FOR": "f77-cpp-input",
+	".f90": "f95",
+	".f95": "f95",
+	".f03": "f95",
+	".f08": "f95",
+	".F90": "f95-cpp-input",
+	".F95": "f95-cpp-input",
+	".F03": "f95-cpp-input",
+	".F08": "f95-cpp-input",
+	".s":   "assembler",
+	".S":   "assembler-with-cpp",
+	".sx":  "assembler-with-cpp",
+}
+
+// sourceLanguages are the languages whose sources are compiled into objects.
+var sourceLanguages = []string{
+	"c", "cpp-output",
+	"c++", "c++-cpp-output",
+	"objective-c", "objective-c-cpp-output",
+	"objective-c++", "objective-c++-cpp-output",
+	"f77", "f77-cpp-input", "f95", "f95-cpp-input",
+	"assembler", "assembler-with-cpp",
+}
+
+// Language returns the language that the given input file is compiled as.
+// It's the language given with the last -x flag before the file, or the one
+// guessed from the file's extension. An empty string is returned if the file
+// isn't compiled, like object files.
+func (inv *Invocation) Language(name string) string {
+	// Canonical always returns the arguments that it has parsed.
+	args, _ := shortflag.Canonical(inv.Args, gccOpts)
+
+	var lang string
+
+	for _, arg := range args {
+		switch {
+		case arg.Name == "-x":
+			lang = arg.Value
+		case !arg.IsFlag() && arg.Value == name:
+			if lang != "" && lang != "none" {
+				return lang
+			}
+			return languageExts[filepath.Ext(name)]
+		}
+	}
+
+	return ""
+}
+
+// Languages returns the language of every input file in order.
+func (inv *Invocation) Languages() []string {
+	names := inv.InputNames()
+	langs := make([]string, len(names))
+	for i, name := range names {
+		langs[i] = inv.Language(name)
+	}
+	return langs
+}
+
+// isSource returns true if the input file is compiled into an object.
+func isSource(inv *Invocation, name string) bool {
+	return containsStrs(sourceLanguages, inv.Language(name))
+}
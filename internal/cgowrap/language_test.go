@@ -0,0 +1,43 @@
+package cgowrap
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLanguage(t *testing.T) {
+	tests := []struct {
+		args   []string
+		name   string
+		expect string
+	}{
+		{[]string{"-c", "a.c"}, "a.c", "c"},
+		{[]string{"-c", "a.cc"}, "a.cc", "c++"},
+		{[]string{"-c", "a.C"}, "a.C", "c++"},
+		{[]string{"-c", "a.m"}, "a.m", "objective-c"},
+		{[]string{"-c", "a.f90"}, "a.f90", "f95"},
+		{[]string{"-c", "a.S"}, "a.S", "assembler-with-cpp"},
+		{[]string{"-c", "a.o"}, "a.o", ""},
+		{[]string{"-x", "c++", "-c", "a.c"}, "a.c", "c++"},
+		{[]string{"-xc", "-c", "-"}, "-", "c"},
+		{[]string{"-x", "c++", "-x", "none", "-c", "a.c"}, "a.c", "c"},
+		{[]string{"-c", "a.c", "-x", "c++"}, "a.c", "c"},
+		{[]string{"-o", "a.c", "-c", "b.c"}, "a.c", ""},
+	}
+
+	for _, test := range tests {
+		inv := NewInvocation(CCDriver, test.args, "/", nil)
+		if lang := inv.Language(test.name); lang != test.expect {
+			t.Errorf("%q: expected %s to be %q, got %q", test.args, test.name, test.expect, lang)
+		}
+	}
+}
+
+func TestLanguages(t *testing.T) {
+	inv := NewInvocation(CCDriver, []string{"-c", "a.c", "-x", "c++", "b.c", "-x", "none", "c.cc", "d.o"}, "/", nil)
+
+	expect := []string{"c", "c++", "c++", ""}
+	if langs := inv.Languages(); !reflect.DeepEqual(expect, langs) {
+		t.Errorf("expected %q, got %q", expect, langs)
+	}
+}
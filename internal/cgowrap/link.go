@@ -0,0 +1,288 @@
+package cgowrap
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/diamondburned/cgowrap/internal/logg"
+	"github.com/diamondburned/cgowrap/internal/shortflag"
+)
+
+// LinkClassifier classifies the links of object files that cgo does when go
+// build links _cgo_main.o with the package's objects into _cgo_.o for
+// -dynimport. The go linker's final link of the executable isn't matched, since
+// its go.o differs between builds.
+type LinkClassifier struct{}
+
+func (LinkClassifier) Name() string   { return "link" }
+func (LinkClassifier) Bucket() string { return linkBucket }
+
+// Depfile returns false, since the key already covers the content of all
+// inputs.
+func (LinkClassifier) Depfile() bool { return false }
+
+func (LinkClassifier) Outputs(inv *Invocation) map[string]string {
+	return outputFlag(inv)
+}
+
+// Relocatable returns true, since the linker doesn't add paths of its own. The
+// paths in the objects are covered by their content.
+func (LinkClassifier) Relocatable(inv *Invocation, base string) bool { return true }
+
+func (LinkClassifier) Match(inv *Invocation) bool {
+	inputs := linkInputs(inv)
+	if len(inputs) == 0 {
+		return false
+	}
+
+	cgo := filepath.Base(inv.Output()) == "_cgo_.o"
+
+	for _, input := range inputs {
+		if !isObjectFile(input) || isGoLinkDir(filepath.Dir(input)) {
+			return false
+		}
+		if filepath.Base(input) == "_cgo_main.o" {
+			cgo = true
+		}
+	}
+
+	return cgo
+}
+
+// isGoLinkDir returns true if the directory is a temporary directory of the go
+// linker.
+func isGoLinkDir(dir string) bool {
+	return strings.HasPrefix(filepath.Base(dir), "go-link-")
+}
+
+// linkInputs returns the input files of an invocation that links into an
+// output given with -o, or nil if the invocation doesn't link.
+func linkInputs(inv *Invocation) []string {
+	args := inv.Args
+
+	for _, arg := range args {
+		switch arg {
+		case "-c", "-E", "-S", "-M", "-MM", "-fsyntax-only":
+			return nil
+		}
+	}
+
+	f, err := shortflag.Parse(args, gccOpts)
+	if err != nil || f.Flag("-o") == nil {
+		return nil
+	}
+
+	return shortflag.NonFlags(f.Args)
+}
+
+func isObjectFile(path string) bool {
+	switch filepath.Ext(path) {
+	case ".o", ".a", ".so":
+		return true
+	default:
+		return strings.Contains(filepath.Base(path), ".so.")
+	}
+}
+
+// Key returns the arguments in order, since the order of objects and libraries
+// matters to the linker, but the output path is dropped and every input is
+// replaced with its content. The libraries given with -l are covered by
+// Cache.LibraryMaterial.
+func (LinkClassifier) Key(inv *Invocation) ([]interface{}, error) {
+	args, err := shortflag.Canonical(inv.Args, gccOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	material := []interface{}{inv.Dir}
+
+	for _, arg := range args {
+		switch {
+		case arg.Name == "-o":
+			// not deterministic
+		case arg.IsFlag():
+			material = append(material, arg.Fields())
+		default:
+			b, err := os.ReadFile(arg.Value)
+			if err != nil {
+				return nil, err
+			}
+			material = append(material, b)
+		}
+	}
+
+	return material, nil
+}
+
+// LinksLibraries returns true, since the libraries given with -l are linked.
+func (LinkClassifier) LinksLibraries() bool { return true }
+
+// LibraryClassifier is a Classifier of invocations that link the libraries
+// given with -l. Where a library is found depends on the compiler, so the
+// fingerprints of the libraries are added to the key by Cache.LibraryMaterial
+// instead of by Key.
+type LibraryClassifier interface {
+	Classifier
+	LinksLibraries() bool
+}
+
+// LibraryMaterial returns the fingerprints of all libraries given with -l, if
+// the invocation of the given kind links them.
+func (c *Cache) LibraryMaterial(kind Classifier, inv *Invocation, cc Compiler) ([]interface{}, error) {
+	if l, ok := kind.(LibraryClassifier); !ok || !l.LinksLibraries() {
+		return nil, nil
+	}
+
+	f, err := shortflag.Parse(inv.Args, gccOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	libs := f.Flag("-l")
+	if libs == nil {
+		return nil, nil
+	}
+
+	var dirs []string
+	if dir := f.Flag("-L"); dir != nil {
+		dirs = append(dirs, dir.Values...)
+	}
+	dirs = append(dirs, c.librarySearchDirs(inv, cc)...)
+
+	static := containsStrs(inv.Args, "-static")
+
+	material := make([]interface{}, len(libs.Values))
+	for i, lib := range libs.Values {
+		material[i] = libraryFingerprint(lib, dirs, static)
+	}
+
+	return material, nil
+}
+
+// BuildClassifier classifies invocations that compile source files and link
+// them with any object files into an output given with -o, such as the go
+// linker's checks of which flags the compiler supports.
+type BuildClassifier struct{}
+
+func (BuildClassifier) Name() string   { return "build" }
+func (BuildClassifier) Bucket() string { return buildBucket }
+
+// Depfile returns true, since the headers of the sources aren't covered by the
+// key.
+func (BuildClassifier) Depfile() bool { return true }
+
+func (BuildClassifier) Outputs(inv *Invocation) map[string]string {
+	return outputFlag(inv)
+}
+
+func (BuildClassifier) Match(inv *Invocation) bool {
+	var sources bool
+
+	for _, input := range linkInputs(inv) {
+		switch {
+		case isSource(inv, input):
+			sources = true
+		case !isObjectFile(input):
+			return false
+		}
+	}
+
+	return sources
+}
+
+// LinksLibraries returns true, since the libraries given with -l are linked.
+func (BuildClassifier) LinksLibraries() bool { return true }
+
+// Key is the key of LinkClassifier, which covers the content of every input,
+// plus the paths of the inputs, since the paths of the sources end up in the
+// output's debug information.
+func (BuildClassifier) Key(inv *Invocation) ([]interface{}, error) {
+	material, err := LinkClassifier{}.Key(inv)
+	if err != nil {
+		return nil, err
+	}
+	return append(material, inv.InputNames()), nil
+}
+
+// Relocatable is the same as ObjectClassifier's, since the sources are compiled
+// into the output.
+func (BuildClassifier) Relocatable(inv *Invocation, base string) bool {
+	return prefixMapped(inv.Args, base)
+}
+
+// libraryFingerprint resolves the library given with -l in the same way that
+// the linker does and returns its path, size and modification time. If the
+// library cannot be found, then only its name is returned.
+func libraryFingerprint(lib string, dirs []string, static bool) string {
+	var names []string
+	switch {
+	case strings.HasPrefix(lib, ":"):
+		names = []string{lib[1:]}
+	case static:
+		names = []string{"lib" + lib + ".a"}
+	default:
+		names = []string{"lib" + lib + ".so", "lib" + lib + ".a"}
+	}
+
+	for _, dir := range dirs {
+		for _, name := range names {
+			path := filepath.Join(dir, name)
+
+			s, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+
+			return fmt.Sprintf("-l%s=%s:%d:%d", lib, path, s.Size(), s.ModTime().UnixNano())
+		}
+	}
+
+	return "-l" + lib
+}
+
+// librarySearchDirs returns the compiler's default library search directories.
+// They're memoized per compiler.
+func (c *Cache) librarySearchDirs(inv *Invocation, cc Compiler) []string {
+	h := sha256.New()
+	h.Write([]byte(cc.ID))
+	for _, v := range cc.Env() {
+		h.Write([]byte(v))
+		h.Write([]byte{0})
+	}
+	keys := []string{compilerBucket, "libdirs", hex.EncodeToString(h.Sum(nil))}
+
+	if b, err := getKVBytes(c.db, keys); err == nil {
+		if len(b) == 0 {
+			return nil
+		}
+		return strings.Split(string(b), "\n")
+	}
+
+	b, err := exec.Command(inv.Driver.Compiler(), "-print-search-dirs").Output()
+	if err != nil {
+		return nil
+	}
+
+	var dirs []string
+
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "libraries: =") {
+			dirs = filepath.SplitList(strings.TrimPrefix(line, "libraries: ="))
+			break
+		}
+	}
+
+	err = setKV(c.db, keys, []byte(strings.Join(dirs, "\n")))
+	logg.DebugFatalErr("cannot save library search dirs:", err)
+
+	return dirs
+}
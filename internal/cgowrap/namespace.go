@@ -0,0 +1,175 @@
+package cgowrap
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Namespace separates the keys of different Go toolchains and cgo template
+// variants, since what the cgo classifiers match and how cgo reads the output
+// both depend on the Go release.
+type Namespace struct {
+	// GoVersion is the version of the Go toolchain, or an empty string if
+	// there's none.
+	GoVersion string
+	// Template is the hash of the cgo template that the input is generated
+	// from, or an empty string if the input isn't generated by cgo.
+	Template string
+}
+
+// ID returns a short string that identifies the namespace in entry IDs.
+func (n Namespace) ID() string {
+	sum := sha256.Sum256([]byte(n.GoVersion + "\x00" + n.Template))
+	return base64.RawURLEncoding.EncodeToString(sum[:9])
+}
+
+// Namespace returns the Namespace of the invocation of the given kind.
+func (c *Cache) Namespace(kind Classifier, inv *Invocation) Namespace {
+	ns := Namespace{GoVersion: c.GoVersion()}
+	if t, ok := kind.(TemplateClassifier); ok {
+		ns.Template = t.Template(inv)
+	}
+	return ns
+}
+
+// TemplateClassifier is a Classifier of inputs that cgo generates from its own
+// templates, like the guessKinds probe.
+type TemplateClassifier interface {
+	Classifier
+	// Template returns the hash of the template variant that the input is
+	// generated from.
+	Template(inv *Invocation) string
+}
+
+// cgoLineDirective matches the #line directives that cgo marks the parts of its
+// templates with, like #line 1 "cgo-generated-wrapper". The directives that
+// name the Go files of the preamble are told apart by their paths.
+var cgoLineDirective = regexp.MustCompile(`(?m)^#line [0-9]+ "([^"/]*)"$`)
+
+// cgoTemplate returns the hash of the distinct #line markers in the input that
+// cgo generated, in the order that they first appear, which is the shape of the
+// template that the input is generated from. Markers that repeat for every name
+// that cgo asks about are only counted once.
+func cgoTemplate(input []byte) string {
+	h := sha256.New()
+	seen := make(map[string]struct{})
+
+	for _, m := range cgoLineDirective.FindAllSubmatch(input, -1) {
+		name := string(m[1])
+		if _, ok := seen[name]; ok || strings.HasSuffix(name, ".go") {
+			continue
+		}
+		seen[name] = struct{}{}
+
+		h.Write(m[1])
+		h.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// GoVersion returns the version of the Go toolchain that runs the compiler,
+// which is the parent process: cgo for its own probes, link for external
+// linking, and go for everything else that it builds. The parent is found
+// through /proc, so off Linux, GOVERSION, the VERSION file in GOROOT and go env
+// GOVERSION are tried in that order. An empty string is returned if there's no
+// Go toolchain.
+func (c *Cache) GoVersion() string {
+	if exe, err := os.Readlink("/proc/" + strconv.Itoa(os.Getppid()) + "/exe"); err == nil {
+		if v := ToolchainVersion(exe); v != "" {
+			return v
+		}
+	}
+
+	if v := os.Getenv("GOVERSION"); v != "" {
+		return v
+	}
+
+	if goroot := os.Getenv("GOROOT"); goroot != "" {
+		if v := goRootVersion(goroot); v != "" {
+			return v
+		}
+	}
+
+	return c.goEnvVersion()
+}
+
+// goEnvVersion returns the GOVERSION that go env prints for the go binary in
+// PATH. It's memoized per binary, so go is only asked once.
+func (c *Cache) goEnvVersion() string {
+	path, err := exec.LookPath("go")
+	if err != nil {
+		return ""
+	}
+
+	id, err := fileID(path)
+	if err != nil {
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(id))
+	keys := []string{compilerBucket, "go", hex.EncodeToString(sum[:])}
+
+	if b, err := getKVBytes(c.db, keys); err == nil {
+		return string(b)
+	}
+
+	out, err := exec.Command(path, "env", "GOVERSION").Output()
+	if err != nil {
+		return ""
+	}
+
+	version := strings.TrimSpace(string(out))
+	setKV(c.db, keys, []byte(version))
+
+	return version
+}
+
+// ToolchainVersion returns the version of the Go toolchain that the given go
+// binary or tool binary like cgo or link belongs to, which is the first line of
+// the VERSION file in its GOROOT. A toolchain without one, like a development
+// build, is identified by the binary itself. An empty string is returned if
+// the binary isn't part of a Go toolchain.
+func ToolchainVersion(exe string) string {
+	var goroot string
+
+	dir := filepath.Dir(exe)
+	switch {
+	case strings.TrimSuffix(filepath.Base(exe), ".exe") == "go":
+		// $GOROOT/bin/go
+		goroot = filepath.Dir(dir)
+	case filepath.Base(filepath.Dir(dir)) == "tool" && filepath.Base(filepath.Dir(filepath.Dir(dir))) == "pkg":
+		// $GOROOT/pkg/tool/$GOOS_$GOARCH/cgo
+		goroot = filepath.Dir(filepath.Dir(filepath.Dir(dir)))
+	default:
+		return ""
+	}
+
+	if v := goRootVersion(goroot); v != "" {
+		return v
+	}
+
+	id, _ := fileID(exe)
+	return id
+}
+
+// goRootVersion returns the first line of the VERSION file in GOROOT, or an
+// empty string if there's none.
+func goRootVersion(goroot string) string {
+	b, err := os.ReadFile(filepath.Join(goroot, "VERSION"))
+	if err != nil {
+		return ""
+	}
+
+	line, _, _ := bufio.NewReader(bytes.NewReader(b)).ReadLine()
+	return string(line)
+}
@@ -0,0 +1,65 @@
+package cgowrap
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestToolchainVersion(t *testing.T) {
+	goroot := t.TempDir()
+
+	files := map[string]string{
+		"VERSION":                   "go1.99.0\ntime 2099-01-01T00:00:00Z\n",
+		"bin/go":                    "",
+		"pkg/tool/linux_amd64/cgo":  "",
+		"pkg/tool/linux_amd64/link": "",
+		"lib/other":                 "",
+	}
+
+	for name, data := range files {
+		path := filepath.Join(goroot, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := map[string]string{
+		"bin/go":                    "go1.99.0",
+		"pkg/tool/linux_amd64/cgo":  "go1.99.0",
+		"pkg/tool/linux_amd64/link": "go1.99.0",
+		"lib/other":                 "",
+	}
+
+	for name, expect := range tests {
+		if v := ToolchainVersion(filepath.Join(goroot, name)); v != expect {
+			t.Errorf("%s: expected %q, got %q", name, expect, v)
+		}
+	}
+
+	// Development builds have no VERSION file.
+	os.Remove(filepath.Join(goroot, "VERSION"))
+
+	if v := ToolchainVersion(filepath.Join(goroot, "bin/go")); v == "" {
+		t.Error("development build has no version")
+	}
+}
+
+func TestGoEnvVersion(t *testing.T) {
+	c := openTestCache(t)
+
+	bin := t.TempDir()
+	script := "#!/bin/sh\n[ \"$1 $2\" = \"env GOVERSION\" ] && echo go1.99.0\n"
+	if err := os.WriteFile(filepath.Join(bin, "go"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin)
+
+	if v := c.goEnvVersion(); v != "go1.99.0" {
+		t.Errorf("expected go1.99.0, got %q", v)
+	}
+
+}
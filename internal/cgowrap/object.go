@@ -0,0 +1,78 @@
+package cgowrap
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// ObjectClassifier classifies compiles of source files into object files, such
+// as when go build compiles _cgo_export.c, the *.cgo2.c files and the
+// package's own C, C++, Objective-C and Fortran files. A single object file
+// must be given with -o, since that's where it's restored to. Several sources
+// are compiled into objects named after them in the working directory, since
+// the compiler doesn't allow -o then. A source may also be the standard input
+// if its language is given with -x.
+type ObjectClassifier struct{}
+
+func (ObjectClassifier) Name() string   { return "object" }
+func (ObjectClassifier) Bucket() string { return objectBucket }
+func (ObjectClassifier) Depfile() bool  { return true }
+
+func (ObjectClassifier) Match(inv *Invocation) bool {
+	if !containsStrs(inv.Args, "-c") || containsStrs(inv.Args, "-E") {
+		return false
+	}
+
+	names := inv.InputNames()
+	if len(names) == 0 {
+		return false
+	}
+
+	for _, name := range names {
+		if !isSource(inv, name) {
+			return false
+		}
+	}
+
+	if len(names) > 1 {
+		return inv.Output() == ""
+	}
+
+	return inv.Output() != "" && inv.Input() != nil
+}
+
+// Key also covers the source paths, since they end up in the objects' debug
+// information. They're only rewritten with the base directory if the object is
+// Relocatable. cgo's inputs are temporary files, so this isn't done for them.
+// The path of the standard input is "-", so it's covered the same way.
+func (ObjectClassifier) Key(inv *Invocation) ([]interface{}, error) {
+	material, err := inputKey(inv)
+	if err != nil {
+		return nil, err
+	}
+	return append(material, inv.InputNames()), nil
+}
+
+// Relocatable returns true if the base directory is mapped away with the prefix
+// map flags, since the object's debug information and __FILE__ name the paths
+// of the checkout otherwise.
+func (ObjectClassifier) Relocatable(inv *Invocation, base string) bool {
+	return prefixMapped(inv.Args, base)
+}
+
+func (ObjectClassifier) Outputs(inv *Invocation) map[string]string {
+	names := inv.InputNames()
+	if len(names) < 2 {
+		return outputFlag(inv)
+	}
+
+	// The objects are named after the sources without their directories, so
+	// the names can be used in keys.
+	outputs := make(map[string]string, len(names))
+	for _, name := range names {
+		obj := strings.TrimSuffix(filepath.Base(name), filepath.Ext(name)) + ".o"
+		outputs[obj] = filepath.Join(inv.Dir, obj)
+	}
+
+	return outputs
+}
@@ -0,0 +1,95 @@
+package cgowrap
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestObjectClassifierSources(t *testing.T) {
+	cc, err := exec.LookPath(CCDriver.Compiler())
+	if err != nil {
+		t.Skip("no C compiler:", err)
+	}
+
+	c := openTestCache(t)
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.c": "int a(void) { return 1; }\n",
+		"b.c": "#include \"b.h\"\nint b(void) { return B; }\n",
+		"b.h": "#define B 2\n",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	inv := NewInvocation(CCDriver, []string{"-O2", "-c", "a.c", "b.c"}, dir, nil)
+
+	kind := Classify(inv)
+	if kind == nil || kind.Name() != "object" {
+		t.Fatalf("expected object, got %v", kind)
+	}
+
+	outputs := kind.Outputs(inv)
+	expect := map[string]string{"a.o": filepath.Join(dir, "a.o"), "b.o": filepath.Join(dir, "b.o")}
+	if !reflect.DeepEqual(expect, outputs) {
+		t.Fatalf("expected outputs %q, got %q", expect, outputs)
+	}
+
+	// gcc appends a target for every source to SUNPRO_DEPENDENCIES, like the
+	// wrapper asks it to when there are several sources.
+	id := "object.test.d"
+	cmd := exec.Command(cc, inv.Args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "SUNPRO_DEPENDENCIES="+c.Depfile.Path(id))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("cannot compile: %v: %s", err, out)
+	}
+
+	wd, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	if err := c.Depfile.Save(id, []string{dir}, inv.SourceNames()...); err != nil {
+		t.Fatal("cannot save depfile:", err)
+	}
+
+	deps, _, err := c.Depfile.Files(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !containsStrs(deps, "b.h") {
+		t.Errorf("expected b.h in the dependencies, got %q", deps)
+	}
+
+	var out Output
+	if err := out.ReadFiles(outputs); err != nil {
+		t.Fatal(err)
+	}
+
+	material, err := kind.Key(inv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := NewKey(Namespace{}, kind.Name(), material...)
+
+	objects := c.Outputs(objectBucket)
+	if err := objects.Save(key, out); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := objects.Load(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out.Files, loaded.Files) {
+		t.Errorf("expected files %v, got %v", out.Files, loaded.Files)
+	}
+}
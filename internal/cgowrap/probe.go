@@ -0,0 +1,109 @@
+package cgowrap
+
+import (
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/diamondburned/cgowrap/internal/shortflag"
+)
+
+// queryFlags are flags that make the compiler print something about itself
+// without compiling anything.
+var queryFlags = []string{
+	"-dumpversion",
+	"-dumpfullversion",
+	"-dumpmachine",
+	"-dumpspecs",
+	"--version",
+	"-###",
+}
+
+// ProbeClassifier classifies probes of the compiler's capabilities, such as
+// cmd/go's -dumpversion, --version and -print-libgcc-file-name queries and its
+// gccSupportsFlag checks. The latter compile the standard input, which is
+// usually empty, into /dev/null. The standard input may not include any file,
+// since the headers of probes aren't tracked.
+type ProbeClassifier struct{}
+
+func (ProbeClassifier) Name() string   { return "probe" }
+func (ProbeClassifier) Bucket() string { return probeBucket }
+
+// Depfile returns false, since probes don't read any file other than the
+// standard input, which Match checks.
+func (ProbeClassifier) Depfile() bool { return false }
+
+// Outputs returns nil, since probes write nothing but /dev/null.
+func (ProbeClassifier) Outputs(inv *Invocation) map[string]string { return nil }
+
+// Key returns the exact arguments and the standard input if it's read. The
+// working directory isn't part of it, since the output of a probe doesn't
+// depend on it.
+func (ProbeClassifier) Key(inv *Invocation) ([]interface{}, error) {
+	material := []interface{}{inv.Args}
+	if inv.InputName() == shortflag.Stdin {
+		material = append(material, inv.Input())
+	}
+
+	return material, nil
+}
+
+// Relocatable returns true, since probes don't write anything but their
+// diagnostics.
+func (ProbeClassifier) Relocatable(inv *Invocation, base string) bool { return true }
+
+// tempFile matches the paths of temporary files, which -### names randomly.
+var tempFile = regexp.MustCompile(regexp.QuoteMeta(os.TempDir()) + `/[^\s"']+`)
+
+// Normalize masks the temporary files in the commands that -### prints.
+func (ProbeClassifier) Normalize(inv *Invocation, out Output) Output {
+	if containsStrs(inv.Args, "-###") {
+		out.Stderr = tempFile.ReplaceAllLiteral(out.Stderr, []byte("${TMPFILE}"))
+	}
+	return out
+}
+
+// includes matches the directives and operators that read or look up other
+// files.
+var includes = regexp.MustCompile(`(?m)^[ \t]*#[ \t]*(?:include|import|embed)|__has_include|__has_embed`)
+
+// includeFlags are the flags that include a file before the input.
+var includeFlags = []string{"-include", "-imacros", "--include", "--imacros"}
+
+func (ProbeClassifier) Match(inv *Invocation) bool {
+	// Canonical always returns the arguments that it has parsed.
+	args, _ := shortflag.Canonical(inv.Args, gccOpts)
+
+	var query bool
+	var stdin bool
+	var devNull bool
+
+	for _, arg := range args {
+		switch {
+		case arg.Name == "-o":
+			devNull = arg.Value == os.DevNull
+		case containsStrs(includeFlags, arg.Name):
+			return false
+		case arg.IsFlag():
+			if s := arg.String(); strings.HasPrefix(s, "-print-") || containsStrs(queryFlags, s) {
+				query = true
+			}
+		case arg.Value == shortflag.Stdin:
+			stdin = true
+		default:
+			// Probes never have input files.
+			return false
+		}
+	}
+
+	if stdin {
+		if includes.Match(inv.Input()) {
+			return false
+		}
+
+		// Nothing may be written, since there's no output file to restore.
+		return devNull || containsStrs(inv.Args, "-###")
+	}
+
+	return query
+}
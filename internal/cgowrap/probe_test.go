@@ -0,0 +1,29 @@
+package cgowrap
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestProbeClassifierStdin(t *testing.T) {
+	tests := []struct {
+		stdin string
+		args  []string
+		match bool
+	}{
+		{"", []string{"-Werror", "-fno-stack-protector", "-c", "-x", "c", "-", "-o", "/dev/null"}, true},
+		{"int x;\n", []string{"-c", "-x", "c", "-", "-o", "/dev/null"}, true},
+		{"#include <stdio.h>\n", []string{"-c", "-x", "c", "-", "-o", "/dev/null"}, false},
+		{"  # include \"foo.h\"\n", []string{"-c", "-x", "c", "-", "-o", "/dev/null"}, false},
+		{"#if __has_include(<foo.h>)\n#endif\n", []string{"-c", "-x", "c", "-", "-o", "/dev/null"}, false},
+		{"", []string{"-include", "foo.h", "-c", "-x", "c", "-", "-o", "/dev/null"}, false},
+		{"", []string{"-c", "-x", "c", "-", "-o", "a.o"}, false},
+	}
+
+	for _, test := range tests {
+		inv := NewInvocation(CCDriver, test.args, "/", strings.NewReader(test.stdin))
+		if match := (ProbeClassifier{}).Match(inv); match != test.match {
+			t.Errorf("%q with stdin %q: Match = %v, want %v", test.args, test.stdin, match, test.match)
+		}
+	}
+}
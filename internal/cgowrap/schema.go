@@ -0,0 +1,126 @@
+package cgowrap
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// schemaVersion is the version of the cache layout that this cgowrap reads and
+// writes. It must be bumped along with a new migration whenever the layout of
+// the entries changes.
+const schemaVersion = 2
+
+// schemaFile is the file in the cache root that holds the schema version.
+const schemaFile = "schema"
+
+// ErrNewerSchema is returned if the cache was written by a newer cgowrap.
+var ErrNewerSchema = errors.New("cache was written by a newer cgowrap")
+
+// migration upgrades the cache from one schema version to the next. It must be
+// safe to run concurrently with other cgowrap processes, which may be running
+// the same migration or writing entries of the next version.
+type migration func(c *Cache) error
+
+// migrations maps each schema version to the migration that upgrades it to the
+// next version.
+var migrations = map[int]migration{
+	1: migrateUnversioned,
+}
+
+// migrate upgrades the cache to schemaVersion.
+func (c *Cache) migrate() error {
+	version, err := c.schema()
+	if err != nil {
+		return err
+	}
+
+	if version > schemaVersion {
+		return fmt.Errorf(
+			"%w: %s has schema version %d, but this cgowrap only knows up to %d",
+			ErrNewerSchema, WorkDir(), version, schemaVersion)
+	}
+
+	if version == schemaVersion {
+		return nil
+	}
+
+	for ; version < schemaVersion; version++ {
+		migrate, ok := migrations[version]
+		if !ok {
+			return fmt.Errorf("no migration from cache schema version %d", version)
+		}
+		if err := migrate(c); err != nil {
+			return fmt.Errorf("cannot migrate cache from schema version %d: %w", version, err)
+		}
+	}
+
+	return writeSchema()
+}
+
+func writeSchema() error {
+	return writeFileAtomic(WorkFile(schemaFile), []byte(strconv.Itoa(schemaVersion)+"\n"), 0644)
+}
+
+// schema returns the schema version of the cache. A cache without a schema
+// file predates it and is version 1, unless it's empty, in which case it's
+// marked with the current version.
+func (c *Cache) schema() (int, error) {
+	b, err := os.ReadFile(WorkFile(schemaFile))
+	if err == nil {
+		version, err := strconv.Atoi(strings.TrimSpace(string(b)))
+		if err != nil {
+			return 0, fmt.Errorf("invalid cache schema version %q", b)
+		}
+		return version, nil
+	}
+
+	if !errors.Is(err, os.ErrNotExist) {
+		return 0, err
+	}
+
+	entries, err := os.ReadDir(WorkDir("cache"))
+	if err != nil {
+		return 0, err
+	}
+	if len(entries) == 0 {
+		return schemaVersion, writeSchema()
+	}
+
+	return 1, nil
+}
+
+// migrateUnversioned discards the entries written before keys were namespaced
+// and stored along with their key material, since they can never be looked up
+// again. The memoized compiler queries are kept.
+func migrateUnversioned(c *Cache) error {
+	for key := range c.db.Keys(nil) {
+		parts := strings.Split(key, "$")
+		if len(parts) < 2 || parts[0] == compilerBucket || isNamespacedID(parts[1]) {
+			continue
+		}
+		// Another process may have erased it already.
+		c.db.Erase(key)
+	}
+
+	entries, err := os.ReadDir(WorkDir("depfiles"))
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if !isNamespacedID(entry.Name()) {
+			os.Remove(WorkFile("depfiles", entry.Name()))
+		}
+	}
+
+	return nil
+}
+
+// isNamespacedID returns true if the entry ID has a namespace, which is the
+// case since schema version 2.
+func isNamespacedID(id string) bool {
+	return strings.Count(strings.TrimSuffix(id, ".d"), ".") == 2
+}
@@ -0,0 +1,81 @@
+package cgowrap
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMigrateUnversioned(t *testing.T) {
+	c := openTestCache(t)
+
+	// Pretend that the cache was written before the schema file.
+	if err := os.Remove(WorkFile(schemaFile)); err != nil {
+		t.Fatal(err)
+	}
+
+	ns := Namespace{GoVersion: "go1.99.0"}
+	key := NewKey(ns, "object", "a")
+
+	entries := map[string][]byte{
+		joinKeys(objectBucket, "object.oldhash", "json"): []byte("{}"),
+		joinKeys(objectBucket, "object.oldhash", "out"):  nil,
+		joinKeys(compilerBucket, "somecompiler"):         []byte("gcc 12"),
+		joinKeys(objectBucket, key.ID(), "json"):         []byte("{}"),
+		joinKeys(depfileBucket, "object.oldhash.d"):      []byte("{}"),
+		joinKeys(depfileBucket, key.ID()+".d"):           []byte("{}"),
+	}
+	for k, v := range entries {
+		if err := c.db.Write(k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	depfiles := []string{"object.oldhash.d", key.ID() + ".d"}
+	for _, name := range depfiles {
+		if err := os.WriteFile(WorkFile("depfiles", name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := OpenCache(); err != nil {
+		t.Fatal(err)
+	}
+
+	for k := range entries {
+		kept := !strings.Contains(k, "oldhash")
+		if c.db.Has(k) != kept {
+			t.Errorf("%s: expected kept to be %v", k, kept)
+		}
+	}
+
+	for _, name := range depfiles {
+		_, err := os.Stat(WorkFile("depfiles", name))
+		if kept := !strings.Contains(name, "oldhash"); kept != (err == nil) {
+			t.Errorf("depfile %s: expected kept to be %v, got %v", name, kept, err)
+		}
+	}
+
+	version, err := c.schema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != schemaVersion {
+		t.Errorf("expected schema version %d, got %d", schemaVersion, version)
+	}
+}
+
+func TestNewerSchema(t *testing.T) {
+	openTestCache(t)
+
+	newer := []byte(strconv.Itoa(schemaVersion+1) + "\n")
+	if err := os.WriteFile(WorkFile(schemaFile), newer, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := OpenCache(); !errors.Is(err, ErrNewerSchema) {
+		t.Errorf("expected ErrNewerSchema, got %v", err)
+	}
+}
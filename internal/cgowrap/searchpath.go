@@ -0,0 +1,201 @@
+package cgowrap
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/diamondburned/cgowrap/internal/logg"
+	"github.com/diamondburned/cgowrap/internal/shortflag"
+)
+
+// ErrShadowed is returned if a file now exists where the compiler looked for a
+// header before finding it elsewhere, so the header may resolve differently.
+var ErrShadowed = errors.New("new file shadows a dependency")
+
+// searchPathFlags are the flags that add directories to the include search
+// path, in the order that their directories are searched.
+var searchPathFlags = []string{"-iquote", "-I", "-isystem", "-idirafter"}
+
+// defaultDirsFlags are the flags that change the compiler's default include
+// directories.
+var defaultDirsFlags = []string{
+	"--sysroot", "-isysroot", "-nostdinc", "-nostdinc++", "-target", "--target", "-m",
+}
+
+// SearchPath returns the directories that the compiler searches for headers
+// in order: the directories of the sources, then the ones given with -iquote,
+// -I and -isystem, then the compiler's default directories and finally the
+// ones given with -idirafter. The directories of the sources and -iquote are
+// only searched for quoted includes, but they're included regardless, since
+// the depfile doesn't tell how a header was included. Relative directories are
+// made absolute against the invocation's directory.
+func (c *Cache) SearchPath(inv *Invocation, cc Compiler) []string {
+	abs := func(dir string) string {
+		if filepath.IsAbs(dir) {
+			return filepath.Clean(dir)
+		}
+		return filepath.Join(inv.Dir, dir)
+	}
+
+	var dirs []string
+	for _, name := range inv.SourceNames() {
+		if name != "-" {
+			dirs = append(dirs, abs(filepath.Dir(name)))
+		}
+	}
+
+	flagDirs := make(map[string][]string, len(searchPathFlags))
+
+	// Canonical always returns the arguments that it has parsed.
+	args, _ := shortflag.Canonical(inv.Args, gccOpts)
+
+	for _, arg := range args {
+		if containsStrs(searchPathFlags, arg.Name) {
+			flagDirs[arg.Name] = append(flagDirs[arg.Name], abs(arg.Value))
+		}
+	}
+
+	dirs = append(dirs, flagDirs["-iquote"]...)
+	dirs = append(dirs, flagDirs["-I"]...)
+	dirs = append(dirs, flagDirs["-isystem"]...)
+	dirs = append(dirs, c.defaultDirs(inv, cc)...)
+	dirs = append(dirs, flagDirs["-idirafter"]...)
+
+	return dirs
+}
+
+// defaultDirs returns the compiler's default include directories for the
+// language of the invocation's first source. They're memoized per compiler,
+// language and the flags that change them.
+func (c *Cache) defaultDirs(inv *Invocation, cc Compiler) []string {
+	lang := "c"
+	if sources := inv.SourceNames(); len(sources) > 0 {
+		if l := inv.Language(sources[0]); l != "" {
+			lang = l
+		}
+	}
+
+	args := []string{"-x", lang, "-E", "-v", "-"}
+	for _, arg := range inv.Args {
+		for _, flag := range defaultDirsFlags {
+			if strings.HasPrefix(arg, flag) {
+				args = append(args, arg)
+				break
+			}
+		}
+	}
+
+	h := sha256.New()
+	h.Write([]byte(cc.ID))
+	for _, v := range append(args, cc.Env()...) {
+		h.Write([]byte(v))
+		h.Write([]byte{0})
+	}
+	keys := []string{compilerBucket, "dirs", hex.EncodeToString(h.Sum(nil))}
+
+	if b, err := getKVBytes(c.db, keys); err == nil {
+		if len(b) == 0 {
+			return nil
+		}
+		return strings.Split(string(b), "\n")
+	}
+
+	cmd := exec.Command(inv.Driver.Compiler(), args...)
+	cmd.Stdin = bytes.NewReader(nil)
+
+	// The search list is printed to stderr.
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil
+	}
+
+	dirs := parseSearchList(out)
+	err = setKV(c.db, keys, []byte(strings.Join(dirs, "\n")))
+	logg.DebugFatalErr("cannot save default include dirs:", err)
+
+	return dirs
+}
+
+// parseSearchList parses the search list printed by the compiler with -E -v.
+func parseSearchList(out []byte) []string {
+	var dirs []string
+	var list bool
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		switch {
+		case strings.HasPrefix(line, "#include <...> search starts here:"):
+			list = true
+		case strings.HasPrefix(line, "End of search list."):
+			return dirs
+		case list && strings.HasPrefix(line, " "):
+			// clang marks framework directories with a suffix.
+			dir := strings.TrimSuffix(strings.TrimSpace(line), " (framework directory)")
+			dirs = append(dirs, filepath.Clean(dir))
+		}
+	}
+
+	return dirs
+}
+
+// AbsentPaths returns the paths that the compiler looked up without finding
+// anything before it found each of the files, given its search path. A file
+// that's later created at one of these paths would shadow the dependency. The
+// directories must be absolute, and relative files are made absolute against
+// the working directory, which is where the compiler ran.
+func AbsentPaths(files []string, dirs []string) []string {
+	var absent []string
+	seen := make(map[string]struct{})
+
+	for _, file := range files {
+		if abs, err := filepath.Abs(file); err == nil {
+			file = abs
+		}
+
+		// The header was found in the directory that gives it the shortest
+		// name, which is the most specific one.
+		found := -1
+		var name string
+
+		for i, dir := range dirs {
+			rel, err := filepath.Rel(dir, file)
+			if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+				continue
+			}
+			if found == -1 || len(rel) < len(name) {
+				found = i
+				name = rel
+			}
+		}
+
+		for _, dir := range dirs[:found+1] {
+			path := filepath.Join(dir, name)
+			if path == filepath.Clean(file) {
+				break
+			}
+
+			if _, ok := seen[path]; ok {
+				continue
+			}
+			seen[path] = struct{}{}
+
+			// Paths that exist now are found by #include_next or are the same
+			// file through another directory, so they aren't lookups that
+			// failed.
+			if _, err := os.Lstat(path); errors.Is(err, os.ErrNotExist) {
+				absent = append(absent, path)
+			}
+		}
+	}
+
+	return absent
+}
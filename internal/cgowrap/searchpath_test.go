@@ -0,0 +1,102 @@
+package cgowrap
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/diamondburned/cgowrap/internal/depfile"
+)
+
+func TestAbsentPaths(t *testing.T) {
+	dir := t.TempDir()
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	for _, name := range []string{"inc1", "inc2", "sys"} {
+		if err := os.Mkdir(path(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"inc2/foo.h", "sys/bar.h", "sys/foo.h"} {
+		if err := os.WriteFile(path(name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dirs := []string{path("inc1"), path("inc2"), path("sys")}
+	files := []string{path("inc2/foo.h"), path("sys/bar.h"), path("sys/foo.h")}
+
+	// sys/foo.h is found by #include_next after inc2/foo.h, so inc2/foo.h
+	// isn't a lookup that failed.
+	absent := AbsentPaths(files, dirs)
+	expect := []string{path("inc1/foo.h"), path("inc1/bar.h"), path("inc2/bar.h")}
+
+	if !reflect.DeepEqual(expect, absent) {
+		t.Fatalf("expected %q, got %q", expect, absent)
+	}
+
+	c := openTestCache(t)
+
+	err := c.Depfile.SaveFile("object.test", &depfile.File{
+		Sources: map[string]depfile.FileList{"a.o": files},
+	}, absent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Depfile.Validate("object.test"); err != nil {
+		t.Fatal("fresh depfile is invalid:", err)
+	}
+
+	// A header created where the compiler looked first shadows the one that
+	// it found.
+	if err := os.WriteFile(path("inc1/bar.h"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Depfile.Validate("object.test"); !errors.Is(err, ErrShadowed) {
+		t.Errorf("expected ErrShadowed, got %v", err)
+	}
+}
+
+func TestSearchPathRelative(t *testing.T) {
+	c := openTestCache(t)
+
+	// A compiler without default include directories.
+	bin := t.TempDir()
+	script := "#!/bin/sh\necho '#include <...> search starts here:' >&2\necho 'End of search list.' >&2\n"
+	if err := os.WriteFile(filepath.Join(bin, "cc"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CGOWRAP_CC", filepath.Join(bin, "cc"))
+
+	dir := t.TempDir()
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	for _, name := range []string{"inc1", "inc2"} {
+		if err := os.Mkdir(path(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(path("inc2/foo.h"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	inv := NewInvocation(CCDriver, []string{"-I", "inc1", "-Iinc2", "-c", "a.c"}, dir, nil)
+	expect := []string{dir, path("inc1"), path("inc2")}
+
+	// The second search path has the memoized default directories, which are
+	// none.
+	for i := 0; i < 2; i++ {
+		if dirs := c.SearchPath(inv, Compiler{ID: "cc"}); !reflect.DeepEqual(expect, dirs) {
+			t.Fatalf("expected %q, got %q", expect, dirs)
+		}
+	}
+
+	absent := AbsentPaths([]string{path("inc2/foo.h")}, c.SearchPath(inv, Compiler{ID: "cc"}))
+	if expect := []string{path("foo.h"), path("inc1/foo.h")}; !reflect.DeepEqual(expect, absent) {
+		t.Errorf("expected %q, got %q", expect, absent)
+	}
+}
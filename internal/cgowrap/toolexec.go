@@ -0,0 +1,300 @@
+package cgowrap
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/diamondburned/cgowrap/internal/depfile"
+)
+
+// CgoBucket is the bucket that whole cgo runs are cached in.
+const CgoBucket = "cgo"
+
+// DepLogEnv is the environment variable naming the file that compiler
+// invocations append their dependencies to. It's set by toolexec while running
+// cgo, so that the headers of the whole run are known.
+const DepLogEnv = "CGOWRAP_DEPLOG"
+
+// depLogUnknown is written into the dependency log by invocations whose
+// dependencies aren't known.
+const depLogUnknown = "!"
+
+// depLogAbsent prefixes the paths in the dependency log that must stay absent.
+const depLogAbsent = "?"
+
+// cgoEnv are the environment variables that cgo reads.
+var cgoEnv = []string{
+	"GOOS",
+	"GOARCH",
+	"GO386",
+	"GOAMD64",
+	"GOARM",
+	"GOMIPS",
+	"GOMIPS64",
+	"GOPPC64",
+	"GORISCV64",
+	"GOWASM",
+	"CGO_LDFLAGS",
+}
+
+// CgoRun describes a run of the cgo tool under go build -toolexec.
+type CgoRun struct {
+	// Tool is the path to the cgo binary.
+	Tool string
+	// Args contains the arguments given to cgo.
+	Args []string
+	// Dir is the working directory of cgo.
+	Dir string
+	// Objdir is the directory that cgo writes all its files into.
+	Objdir string
+}
+
+// ParseCgoRun parses the tool invocation given by go build -toolexec. False is
+// returned if the tool isn't cgo or if cgo isn't generating code into an
+// objdir, like when it's asked for its version or for -dynimport.
+func ParseCgoRun(tool string, args []string, dir string) (*CgoRun, bool) {
+	if strings.TrimSuffix(filepath.Base(tool), ".exe") != "cgo" {
+		return nil, false
+	}
+
+	run := CgoRun{Tool: tool, Args: args, Dir: dir}
+
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--":
+			// Only compiler flags and files come after this.
+			i = len(args)
+		case arg == "-objdir" && i+1 < len(args):
+			i++
+			run.Objdir = args[i]
+		case strings.HasPrefix(arg, "-objdir="):
+			run.Objdir = strings.TrimPrefix(arg, "-objdir=")
+		case strings.HasPrefix(arg, "-V"),
+			strings.HasPrefix(arg, "-dynimport"),
+			strings.HasPrefix(arg, "-godefs"):
+			return nil, false
+		}
+	}
+
+	if run.Objdir == "" {
+		return nil, false
+	}
+
+	return &run, true
+}
+
+// Key returns the key material of the run: the identity of cgo, the
+// environment that cgo reads, the arguments with the objdir replaced by a
+// placeholder, and the paths and contents of the Go files. The identity and
+// the environment of the C compiler aren't part of it, so they must be added
+// with Cache.Compiler.
+func (r *CgoRun) Key() ([]interface{}, error) {
+	toolID, err := fileID(r.Tool)
+	if err != nil {
+		return nil, err
+	}
+
+	material := []interface{}{r.Dir, toolID}
+
+	for _, env := range cgoEnv {
+		material = append(material, env+"="+os.Getenv(env))
+	}
+
+	objdir := strings.TrimSuffix(r.Objdir, string(filepath.Separator))
+
+	var files bool
+	for _, arg := range r.Args {
+		material = append(material, strings.ReplaceAll(arg, objdir, "$OBJDIR"))
+
+		if arg == "--" {
+			files = true
+			continue
+		}
+
+		if files && strings.HasSuffix(arg, ".go") {
+			b, err := os.ReadFile(arg)
+			if err != nil {
+				return nil, err
+			}
+			material = append(material, b)
+		}
+	}
+
+	return material, nil
+}
+
+// Relocatable returns true if cgo is given a -trimpath rule that rewrites the
+// base directory, since the generated files name the Go files in their line
+// directives otherwise.
+func (r *CgoRun) Relocatable(base string) bool {
+	for i := 0; i < len(r.Args); i++ {
+		if r.Args[i] == "--" {
+			// Only compiler flags and files come after this.
+			return false
+		}
+
+		var rules string
+
+		// Go flags may have one or two dashes.
+		switch arg := strings.TrimLeft(r.Args[i], "-"); {
+		case arg == "trimpath" && i+1 < len(r.Args):
+			i++
+			rules = r.Args[i]
+		case strings.HasPrefix(arg, "trimpath="):
+			rules = strings.TrimPrefix(arg, "trimpath=")
+		default:
+			continue
+		}
+
+		for _, rule := range strings.Split(rules, ";") {
+			from := rule
+			if j := strings.Index(rule, "=>"); j != -1 {
+				from = rule[:j]
+			}
+			if underDir(base, from) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Snapshot records the files currently in the objdir.
+func (r *CgoRun) Snapshot() map[string]time.Time {
+	entries, _ := os.ReadDir(r.Objdir)
+	snapshot := make(map[string]time.Time, len(entries))
+
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil {
+			snapshot[entry.Name()] = info.ModTime()
+		}
+	}
+
+	return snapshot
+}
+
+// Outputs returns the paths of the files that cgo wrote into the objdir since
+// the given snapshot, keyed by their names.
+func (r *CgoRun) Outputs(snapshot map[string]time.Time) map[string]string {
+	entries, _ := os.ReadDir(r.Objdir)
+	outputs := make(map[string]string, len(entries))
+
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		if t, ok := snapshot[entry.Name()]; ok && t.Equal(info.ModTime()) {
+			continue
+		}
+
+		outputs[entry.Name()] = filepath.Join(r.Objdir, entry.Name())
+	}
+
+	return outputs
+}
+
+// RestorePaths returns the paths in the objdir that the files of a cached run
+// are restored to.
+func (r *CgoRun) RestorePaths(out Output) map[string]string {
+	paths := make(map[string]string, len(out.Files))
+	for name := range out.Files {
+		paths[name] = filepath.Join(r.Objdir, filepath.Base(name))
+	}
+	return paths
+}
+
+// AppendDepLog appends the given dependencies and the paths that must stay
+// absent to the dependency log. If known is false, then the log is marked as
+// incomplete instead.
+func AppendDepLog(path string, files, absent []string, known bool) error {
+	if !known {
+		files = []string{depLogUnknown}
+		absent = nil
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	for _, file := range files {
+		w.WriteString(file)
+		w.WriteByte('\n')
+	}
+	for _, file := range absent {
+		w.WriteString(depLogAbsent)
+		w.WriteString(file)
+		w.WriteByte('\n')
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+// ErrUnknownDeps is returned by ReadDepLog if an invocation didn't know its
+// dependencies.
+var ErrUnknownDeps = errors.New("dependencies of an invocation are unknown")
+
+// ReadDepLog reads the dependency log into a list of unique files and a list
+// of unique paths that must stay absent.
+func ReadDepLog(path string) (files depfile.FileList, absent []string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			// No invocation has written anything.
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	seen := make(map[string]struct{})
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == depLogUnknown {
+			return nil, nil, ErrUnknownDeps
+		}
+
+		if _, ok := seen[line]; ok {
+			continue
+		}
+		seen[line] = struct{}{}
+
+		if strings.HasPrefix(line, depLogAbsent) {
+			absent = append(absent, strings.TrimPrefix(line, depLogAbsent))
+		} else {
+			files = append(files, line)
+		}
+	}
+
+	return files, absent, scanner.Err()
+}
+
+// fileID returns a string that identifies the file at the given path, which is
+// its path, size and modification time.
+func fileID(path string) (string, error) {
+	s, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s:%d:%d", path, s.Size(), s.ModTime().UnixNano()), nil
+}
@@ -0,0 +1,76 @@
+package cgowrap
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseCgoRun(t *testing.T) {
+	tool := "/usr/local/go/pkg/tool/linux_amd64/cgo"
+
+	tests := []struct {
+		tool   string
+		args   []string
+		objdir string
+	}{
+		{tool, []string{"-objdir", "/w/b001/", "-importpath", "example.com/a", "--", "-I", "/w/b001/", "a.go"}, "/w/b001/"},
+		{tool, []string{"-objdir=/w/b002/", "--", "a.go"}, "/w/b002/"},
+		{tool + ".exe", []string{"-objdir", "/w/b003/", "--", "a.go"}, "/w/b003/"},
+		// Not cgo.
+		{"/usr/local/go/pkg/tool/linux_amd64/compile", []string{"-objdir", "/w/b001/"}, ""},
+		// No objdir, or not generating code.
+		{tool, []string{"--", "a.go"}, ""},
+		{tool, []string{"-V=full"}, ""},
+		{tool, []string{"-dynimport", "/w/b001/_cgo_.o", "-dynout", "/w/b001/_cgo_import.go"}, ""},
+		{tool, []string{"-objdir", "/w/b001/", "-godefs", "--", "a.go"}, ""},
+		// The objdir flag belongs to the compiler after --.
+		{tool, []string{"--", "-objdir", "/w/b001/", "a.go"}, ""},
+	}
+
+	for _, test := range tests {
+		run, ok := ParseCgoRun(test.tool, test.args, "/src")
+		if ok != (test.objdir != "") {
+			t.Errorf("%s %q: expected ok to be %v", test.tool, test.args, test.objdir != "")
+			continue
+		}
+		if ok && run.Objdir != test.objdir {
+			t.Errorf("%s %q: expected objdir %q, got %q", test.tool, test.args, test.objdir, run.Objdir)
+		}
+	}
+}
+
+func TestDepLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deplog")
+
+	files, absent, err := ReadDepLog(path)
+	if err != nil || files != nil || absent != nil {
+		t.Fatalf("missing log: expected nothing, got %q, %q and %v", files, absent, err)
+	}
+
+	if err := AppendDepLog(path, []string{"a.h", "b.h"}, []string{"x/a.h"}, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := AppendDepLog(path, []string{"b.h", "c.h"}, []string{"x/a.h", "x/c.h"}, true); err != nil {
+		t.Fatal(err)
+	}
+
+	files, absent, err = ReadDepLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := []string{"a.h", "b.h", "c.h"}; !reflect.DeepEqual([]string(files), expect) {
+		t.Errorf("expected files %q, got %q", expect, files)
+	}
+	if expect := []string{"x/a.h", "x/c.h"}; !reflect.DeepEqual(absent, expect) {
+		t.Errorf("expected absent %q, got %q", expect, absent)
+	}
+
+	if err := AppendDepLog(path, []string{"d.h"}, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ReadDepLog(path); !errors.Is(err, ErrUnknownDeps) {
+		t.Errorf("expected ErrUnknownDeps, got %v", err)
+	}
+}
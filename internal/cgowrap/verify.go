@@ -0,0 +1,102 @@
+package cgowrap
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Mismatch describes a cache hit whose output differs from what the compiler
+// makes when it's ran again.
+type Mismatch struct {
+	// ID is the ID of the entry.
+	ID string `json:"id"`
+	// Args contains the arguments given to the compiler.
+	Args []string `json:"args"`
+	// Dir is the working directory of the compiler.
+	Dir string `json:"dir"`
+	// Diff contains the parts of the output that differ, as returned by
+	// Output.Diff.
+	Diff []string `json:"diff"`
+	// Material is the encoded key material of the entry.
+	Material []byte `json:"material"`
+	// Time is when the mismatch was found.
+	Time time.Time `json:"time"`
+}
+
+// NewMismatch creates a Mismatch of the invocation, whose entry has the given
+// key.
+func NewMismatch(key *Key, inv *Invocation, diff []string) Mismatch {
+	return Mismatch{
+		ID:       key.ID(),
+		Args:     inv.Args,
+		Dir:      inv.Dir,
+		Diff:     diff,
+		Material: key.Material(),
+		Time:     time.Now(),
+	}
+}
+
+// Normalizer is implemented by Classifiers whose output isn't reproducible
+// byte for byte. Normalize returns the output with the parts that differ between
+// runs of the same invocation masked or left out, so that verification only
+// compares what's reproducible. The given output must not be modified.
+type Normalizer interface {
+	Classifier
+	Normalize(inv *Invocation, out Output) Output
+}
+
+// Normalize returns the output normalized by the given kind of invocation if
+// it's a Normalizer, or the output as it is otherwise.
+func Normalize(kind Classifier, inv *Invocation, out Output) Output {
+	if n, ok := kind.(Normalizer); ok {
+		return n.Normalize(inv, out)
+	}
+	return out
+}
+
+// Diff compares the output with another byte for byte and returns the parts
+// that differ: "stdout", "stderr", "status", and the names of the output files.
+// Nil is returned if the outputs are the same.
+func (o Output) Diff(other Output) []string {
+	var diff []string
+
+	if !bytes.Equal(o.Stdout, other.Stdout) {
+		diff = append(diff, "stdout")
+	}
+	if !bytes.Equal(o.Stderr, other.Stderr) {
+		diff = append(diff, "stderr")
+	}
+	if o.Status != other.Status {
+		diff = append(diff, "status")
+	}
+
+	var files []string
+	for name, file := range o.Files {
+		if f, ok := other.Files[name]; !ok || !bytes.Equal(file.Data, f.Data) || file.Mode != f.Mode {
+			files = append(files, name)
+		}
+	}
+	for name := range other.Files {
+		if _, ok := o.Files[name]; !ok {
+			files = append(files, name)
+		}
+	}
+	sort.Strings(files)
+
+	return append(diff, files...)
+}
+
+// RecordMismatch writes the mismatch into the mismatches directory of the cache
+// root as a JSON file, and returns its path.
+func (c *Cache) RecordMismatch(m Mismatch) (string, error) {
+	b, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return "", err
+	}
+
+	path := WorkFile("mismatches", m.ID+"."+strconv.FormatInt(m.Time.UnixNano(), 10)+".json")
+	return path, writeFileAtomic(path, b, 0644)
+}
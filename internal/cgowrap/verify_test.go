@@ -0,0 +1,68 @@
+package cgowrap
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOutputDiff(t *testing.T) {
+	out := Output{
+		Stdout: []byte("out"),
+		Stderr: []byte("err"),
+		Files: map[string]File{
+			"-o":  {Data: []byte("obj"), Mode: 0644},
+			"a.o": {Data: []byte("a"), Mode: 0644},
+		},
+	}
+
+	if diff := out.Diff(out); diff != nil {
+		t.Errorf("the same output differs in %q", diff)
+	}
+
+	other := Output{
+		Stdout: []byte("out"),
+		Stderr: []byte("other"),
+		Status: 1,
+		Files: map[string]File{
+			"-o":  {Data: []byte("obj"), Mode: 0755},
+			"b.o": {Data: []byte("b"), Mode: 0644},
+		},
+	}
+
+	expect := []string{"stderr", "status", "-o", "a.o", "b.o"}
+	if diff := out.Diff(other); !reflect.DeepEqual(expect, diff) {
+		t.Errorf("expected %q, got %q", expect, diff)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	out := Output{
+		Stderr: []byte(`"/usr/libexec/cc1" "-o" "` + filepath.Join(os.TempDir(), "ccAbc123.s") + `"`),
+		Files:  map[string]File{"-o": {Data: []byte("obj")}},
+	}
+
+	// -### names a random temporary file.
+	probe := NewInvocation(CCDriver, []string{"-###", "-c", "-x", "c", "-"}, "/", nil)
+	normalized := Normalize(ProbeClassifier{}, probe, out)
+	if !strings.Contains(string(normalized.Stderr), `"${TMPFILE}"`) {
+		t.Errorf("the temporary file isn't masked: %s", normalized.Stderr)
+	}
+	if !strings.Contains(string(out.Stderr), "ccAbc123.s") {
+		t.Error("the given output was modified")
+	}
+
+	// cgo's temporary input names the object's debug information.
+	dwarf := NewInvocation(CCDriver, []string{"-gdwarf-2", "-c", "-o", "_cgo_.o", "/tmp/cgo-123.c"}, "/", nil)
+	if normalized := Normalize(DWARFClassifier{}, dwarf, out); normalized.Files != nil {
+		t.Errorf("expected no files, got %v", normalized.Files)
+	}
+
+	// Other kinds are compared as they are.
+	object := NewInvocation(CCDriver, []string{"-c", "-o", "a.o", "a.c"}, "/", nil)
+	if normalized := Normalize(ObjectClassifier{}, object, out); !reflect.DeepEqual(out, normalized) {
+		t.Errorf("expected %v, got %v", out, normalized)
+	}
+}
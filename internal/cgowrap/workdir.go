@@ -0,0 +1,177 @@
+package cgowrap
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// workDirPattern matches the temporary work directories of go build and of the
+// go linker, which are named go-build and go-link- followed by random digits.
+var workDirPattern = regexp.MustCompile(`/[^\s"'=:,;]*?/go-(?:build|link-)[0-9]+`)
+
+// WorkDirs replaces the temporary work directories that go build gives the
+// compiler, like $WORK in -I $WORK/b001/, with placeholders. They change on
+// every build, so keys, stored depfiles and stored outputs only ever contain
+// the placeholders, which are filled with the current directories on replay.
+// The action directory that the invocation writes into, like $WORK/b001, is
+// replaced as a whole, since its number depends on what else go build builds.
+// The directories are also replaced where they appear with their symlinks
+// resolved, since that's how paths end up in keys. The zero value replaces
+// nothing.
+type WorkDirs struct {
+	// dirs contains the work directories, whose placeholders are their
+	// indices.
+	dirs []string
+	// real contains the work directories with their symlinks resolved, or
+	// empty strings for the ones that don't change.
+	real []string
+	// objdir is the action directory of the invocation, or an empty string if
+	// there's none.
+	objdir string
+	// realObjdir is objdir with its symlinks resolved, or an empty string if
+	// it doesn't change.
+	realObjdir string
+}
+
+// objdirPlaceholder replaces the action directory of the invocation.
+const objdirPlaceholder = "${OBJDIR}"
+
+// actionDirPattern matches the action directory at the start of a path inside
+// a work directory.
+var actionDirPattern = regexp.MustCompile(`^/b[0-9]+(?:/|$)`)
+
+// FindWorkDirs finds the work directories in the given strings, which are
+// usually the arguments and the working directory of an invocation.
+func FindWorkDirs(strs ...string) WorkDirs {
+	var w WorkDirs
+
+	for _, s := range strs {
+		for _, dir := range workDirPattern.FindAllString(s, -1) {
+			if !containsStrs(w.dirs, dir) {
+				w.dirs = append(w.dirs, dir)
+				w.real = append(w.real, realDir(dir))
+			}
+		}
+	}
+
+	return w
+}
+
+// realDir returns the directory with its symlinks resolved, or an empty string
+// if that's the same directory or it cannot be resolved.
+func realDir(dir string) string {
+	real, err := filepath.EvalSymlinks(dir)
+	if err != nil || real == dir {
+		return ""
+	}
+	return real
+}
+
+// SetObjdir sets the action directory of the invocation to the one that the
+// given path, usually the output, is in. Nothing is set if the path isn't
+// inside an action directory.
+func (w *WorkDirs) SetObjdir(path string) {
+	for i, dir := range w.dirs {
+		if !strings.HasPrefix(path, dir) {
+			continue
+		}
+		if m := actionDirPattern.FindString(path[len(dir):]); m != "" {
+			w.objdir = dir + strings.TrimSuffix(m, "/")
+			if w.real[i] != "" {
+				w.realObjdir = w.real[i] + strings.TrimSuffix(m, "/")
+			}
+			return
+		}
+	}
+}
+
+func workDirPlaceholder(i int) string {
+	if i == 0 {
+		return "${WORK}"
+	}
+	return fmt.Sprintf("${WORK%d}", i+1)
+}
+
+// Contains returns true if the path is inside one of the work directories.
+func (w WorkDirs) Contains(path string) bool {
+	for i, dir := range w.dirs {
+		if strings.HasPrefix(path, dir+"/") {
+			return true
+		}
+		if w.real[i] != "" && strings.HasPrefix(path, w.real[i]+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Strip replaces the work directories in s with their placeholders.
+func (w WorkDirs) Strip(s string) string {
+	if w.objdir != "" {
+		s = replacePathPrefix(s, w.objdir, objdirPlaceholder)
+	}
+	if w.realObjdir != "" {
+		s = replacePathPrefix(s, w.realObjdir, objdirPlaceholder)
+	}
+	for i, dir := range w.dirs {
+		s = replacePathPrefix(s, dir, workDirPlaceholder(i))
+		if w.real[i] != "" {
+			s = replacePathPrefix(s, w.real[i], workDirPlaceholder(i))
+		}
+	}
+	return s
+}
+
+// Fill replaces the placeholders in s with the work directories.
+func (w WorkDirs) Fill(s string) string {
+	if w.objdir != "" {
+		s = strings.ReplaceAll(s, objdirPlaceholder, w.objdir)
+	}
+	for i, dir := range w.dirs {
+		s = strings.ReplaceAll(s, workDirPlaceholder(i), dir)
+	}
+	return s
+}
+
+// StripAll replaces the work directories in every string.
+func (w WorkDirs) StripAll(strs []string) []string {
+	return mapStrs(strs, w.Strip)
+}
+
+// StripMaterial replaces the work directories in the key material, including
+// the content of the inputs.
+func (w WorkDirs) StripMaterial(material []interface{}) []interface{} {
+	if len(w.dirs) == 0 {
+		return material
+	}
+
+	stripped := make([]interface{}, len(material))
+	for i, v := range material {
+		switch v := v.(type) {
+		case string:
+			stripped[i] = w.Strip(v)
+		case []string:
+			stripped[i] = w.StripAll(v)
+		case []byte:
+			stripped[i] = []byte(w.Strip(string(v)))
+		default:
+			stripped[i] = v
+		}
+	}
+
+	return stripped
+}
+
+func mapStrs(strs []string, f func(string) string) []string {
+	if strs == nil {
+		return nil
+	}
+
+	mapped := make([]string, len(strs))
+	for i, s := range strs {
+		mapped[i] = f(s)
+	}
+	return mapped
+}
@@ -0,0 +1,91 @@
+package cgowrap
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWorkDirs(t *testing.T) {
+	args := func(work, objdir string) []string {
+		return []string{
+			"-I", work + "/" + objdir + "/", "-I", work + "/b0170/include",
+			"-o", work + "/" + objdir + "/_x001.o", "-c", "a.c",
+		}
+	}
+
+	strip := func(work, objdir string) []string {
+		w := FindWorkDirs(args(work, objdir)...)
+		w.SetObjdir(work + "/" + objdir + "/_x001.o")
+		return w.StripAll(args(work, objdir))
+	}
+
+	a := strip("/tmp/go-build123", "b001")
+	b := strip("/tmp/go-build456", "b017")
+
+	for i := range a {
+		if a[i] != b[i] {
+			t.Errorf("argument %d differs: %q and %q", i, a[i], b[i])
+		}
+	}
+
+	expect := []string{
+		"-I", "${OBJDIR}/", "-I", "${WORK}/b0170/include",
+		"-o", "${OBJDIR}/_x001.o", "-c", "a.c",
+	}
+
+	for i := range expect {
+		if a[i] != expect[i] {
+			t.Errorf("argument %d: expected %q, got %q", i, expect[i], a[i])
+		}
+	}
+
+	w := FindWorkDirs(args("/tmp/go-build456", "b017")...)
+	w.SetObjdir("/tmp/go-build456/b017/_x001.o")
+
+	for i, arg := range args("/tmp/go-build456", "b017") {
+		if filled := w.Fill(a[i]); filled != arg {
+			t.Errorf("argument %d: expected %q, got %q", i, arg, filled)
+		}
+	}
+}
+
+func TestWorkDirsSymlink(t *testing.T) {
+	c := openTestCache(t)
+
+	// Like /var on macOS, which links to /private/var.
+	tmp := t.TempDir()
+	if err := os.Mkdir(filepath.Join(tmp, "private"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("private", filepath.Join(tmp, "var")); err != nil {
+		t.Fatal(err)
+	}
+
+	key := func(build string) []interface{} {
+		work := filepath.Join(tmp, "var", build)
+		if err := os.MkdirAll(filepath.Join(work, "b001"), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		args := []string{"-I", work + "/b001/", "-o", work + "/b001/_x001.o", "-c", "a.c"}
+		inv := NewInvocation(CCDriver, args, "/", nil)
+
+		dirs := FindWorkDirs(args...)
+		dirs.SetObjdir(inv.Output())
+		c.SetWorkDirs(dirs)
+
+		flags, err := canonicalFlags(inv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c.KeyMaterial([]interface{}{flags})
+	}
+
+	a := key("go-build123")
+	b := key("go-build456")
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("the builds have different key material %q and %q", a, b)
+	}
+}
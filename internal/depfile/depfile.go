@@ -4,42 +4,28 @@ import (
 	"bufio"
 	"fmt"
 	"io"
-	"log"
 	"os"
 	"strings"
-	"time"
 )
 
 // FileList describes a list of file paths.
 type FileList []string
 
-// ModTime returns the modification time of the file list. A zero-value time is
-// returned if the list is empty or if no files can be stat'd. Otherwise, the
-// latest ModTime is returned.
-func (l FileList) ModTime() time.Time {
-	var t time.Time
-
-	for _, f := range l {
-		s, err := os.Stat(f)
-		if err != nil {
-			log.Println("missing", f)
-			// TODO: invalidate cache
-			continue
-		}
+// Omit returns the list without the given files.
+func (l FileList) Omit(files ...string) FileList {
+	list := make(FileList, 0, len(l))
 
-		if mod := s.ModTime(); mod.After(t) {
-			t = mod
+outer:
+	for _, file := range l {
+		for _, omit := range files {
+			if file == omit {
+				continue outer
+			}
 		}
+		list = append(list, file)
 	}
 
-	return t
-}
-
-// PopFirst pops the first file off the list and returns it.
-func (l *FileList) PopFirst() string {
-	first := (*l)[0]
-	*l = (*l)[1:]
-	return first
+	return list
 }
 
 // File describes a partial depfile. It is not a complete representation of a
@@ -65,75 +51,129 @@ func ParseFileOnDisk(path string) (*File, error) {
 	return p, nil
 }
 
-// ParseFile parses the given reader.
+// ParseFile parses the given reader. Both Clang's one-file-per-line layout and
+// GCC's layout of several files per line are supported.
 func ParseFile(r io.Reader) (*File, error) {
 	f := File{Sources: make(map[string]FileList)}
 
-	// states
-	var currentSources FileList
-	var currentFile string
-
-	// flush flushes the old file.
-	flush := func() {
-		if currentFile != "" {
-			f.Sources[currentFile] = currentSources
-		}
-	}
+	// rule accumulates a rule that spans several lines.
+	var rule strings.Builder
 
 	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
 		text := scanner.Text()
-		if text == "" {
+
+		if strings.HasSuffix(text, "\\") {
+			// Line continuation.
+			rule.WriteString(strings.TrimSuffix(text, "\\"))
+			rule.WriteByte(' ')
 			continue
 		}
 
-		if strings.HasPrefix(text, "  ") {
-			currentSources = append(currentSources, parsePartialLine(text))
+		rule.WriteString(text)
+		line := rule.String()
+		rule.Reset()
+
+		if strings.TrimSpace(line) == "" {
 			continue
 		}
 
-		// Potentially a new file.
-		parts := strings.SplitN(text, ": ", 2)
-		if len(parts) != 2 {
-			return nil, fmt.Errorf("unexpected line %q", text)
+		if err := f.parseRule(line); err != nil {
+			return nil, err
 		}
+	}
 
-		flush()
+	if rule.Len() > 0 {
+		if err := f.parseRule(rule.String()); err != nil {
+			return nil, err
+		}
+	}
 
-		currentFile = parts[0]
-		currentSources = make([]string, 1, 16)
-		currentSources[0] = parsePartialLine(parts[1])
+	return &f, scanner.Err()
+}
+
+// parseRule parses a single rule with all continuations joined.
+func (f *File) parseRule(line string) error {
+	colon := ruleColon(line)
+	if colon == -1 {
+		return fmt.Errorf("unexpected line %q", line)
 	}
 
-	flush()
+	targets := splitWords(line[:colon])
+	sources := FileList(splitWords(line[colon+1:]))
 
-	return &f, scanner.Err()
+	// Rules without any prerequisites are phony targets written by -MP. They
+	// don't describe any dependency, so skip them.
+	if len(sources) == 0 {
+		return nil
+	}
+
+	for _, target := range targets {
+		f.Sources[target] = sources
+	}
+
+	return nil
 }
 
-func parsePartialLine(line string) string {
-	line = strings.TrimPrefix(line, "  ")
-	line = strings.TrimSuffix(line, " \\")
-	return line
+// ruleColon returns the index of the colon separating the targets from the
+// prerequisites, or -1 if there's none.
+func ruleColon(line string) int {
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++ // skip the escaped character
+		case ':':
+			if i+1 == len(line) || line[i+1] == ' ' || line[i+1] == '\t' {
+				return i
+			}
+		}
+	}
+	return -1
 }
 
-// ModTime returns the latest ModTime in the sources.
-func (f *File) ModTime() time.Time {
-	var t time.Time
+// splitWords splits the line into space-separated words while undoing the
+// escaping done by the compiler.
+func splitWords(line string) []string {
+	var words []string
+	var word strings.Builder
 
-	for _, src := range f.Sources {
-		if mod := src.ModTime(); mod.After(t) {
-			t = mod
+	flush := func() {
+		if word.Len() > 0 {
+			words = append(words, word.String())
+			word.Reset()
 		}
 	}
 
-	return t
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; c {
+		case ' ', '\t':
+			flush()
+		case '\\':
+			if i+1 < len(line) && (line[i+1] == ' ' || line[i+1] == '#') {
+				i++
+				word.WriteByte(line[i])
+			} else {
+				word.WriteByte(c)
+			}
+		case '$':
+			if i+1 < len(line) && line[i+1] == '$' {
+				i++
+			}
+			word.WriteByte(c)
+		default:
+			word.WriteByte(c)
+		}
+	}
+
+	flush()
+	return words
 }
 
-// PopFirstSources removes the first file in all sources. This is useful for
-// getting rid of the input file.
-func (f *File) PopFirstSources() {
+// OmitSources removes the given files from all sources. This is useful for
+// getting rid of the input files, which may be temporary. The compiler doesn't
+// list the standard input, so it cannot be assumed to be the first file.
+func (f *File) OmitSources(files ...string) {
 	for k, src := range f.Sources {
-		src.PopFirst()
-		f.Sources[k] = src
+		f.Sources[k] = src.Omit(files...)
 	}
 }
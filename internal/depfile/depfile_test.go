@@ -1,9 +1,13 @@
 package depfile
 
 import (
+	"errors"
+	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	_ "embed"
 )
@@ -17,7 +21,7 @@ func TestParseFile(t *testing.T) {
 		t.Fatal("cannot parse file:", err)
 	}
 
-	expect := map[string][]string{
+	expect := map[string]FileList{
 		"_obj/_7_cgo_.o": {
 			"/tmp/cgo-gcc-input-2620350145.c",
 			"/nix/store/cn4z6y3pzcr7pry9078rsmd81b8zg3y5-clang-wrapper-7.1.0/resource-root/include/stddef.h",
@@ -203,3 +207,128 @@ func TestParseFile(t *testing.T) {
 		t.Errorf("got:    %#q", f.Sources)
 	}
 }
+
+func TestParseFileGCC(t *testing.T) {
+	const data = `_x001.o: _cgo_export.c /usr/include/stdc-predef.h \
+ /usr/lib/gcc/x86_64-linux-gnu/12/include/stddef.h /usr/include/stdlib.h \
+ dir\ with\ spaces/foo.h cost$$.h
+_x002.o _x003.o: a.c \
+ b.h
+b.h:
+`
+
+	f, err := ParseFile(strings.NewReader(data))
+	if err != nil {
+		t.Fatal("cannot parse file:", err)
+	}
+
+	expect := map[string]FileList{
+		"_x001.o": {
+			"_cgo_export.c",
+			"/usr/include/stdc-predef.h",
+			"/usr/lib/gcc/x86_64-linux-gnu/12/include/stddef.h",
+			"/usr/include/stdlib.h",
+			"dir with spaces/foo.h",
+			"cost$.h",
+		},
+		"_x002.o": {"a.c", "b.h"},
+		"_x003.o": {"a.c", "b.h"},
+	}
+
+	if !reflect.DeepEqual(expect, f.Sources) {
+		t.Errorf("expect: %#q", expect)
+		t.Errorf("got:    %#q", f.Sources)
+	}
+}
+
+func TestFingerprintCheck(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.h")
+	if err := os.WriteFile(path, []byte("#define A 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	plain, err := Stat(path, false)
+	if err != nil {
+		t.Fatal("cannot stat:", err)
+	}
+
+	hashed, err := Stat(path, true)
+	if err != nil {
+		t.Fatal("cannot stat:", err)
+	}
+
+	// Only touch the file.
+	later := plain.ModTime.Add(time.Hour)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := plain.Check(path); !errors.Is(err, ErrChanged) {
+		t.Errorf("touched file without hash: expected ErrChanged, got %v", err)
+	}
+
+	now, err := hashed.Check(path)
+	if err != nil {
+		t.Errorf("touched file with hash: unexpected error: %v", err)
+	}
+	if !now.ModTime.Equal(later) {
+		t.Errorf("touched file with hash: expected new mtime %v, got %v", later, now.ModTime)
+	}
+
+	// Change the content without changing the size.
+	if err := os.WriteFile(path, []byte("#define A 2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, later, later.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := hashed.Check(path); !errors.Is(err, ErrChanged) {
+		t.Errorf("changed file with hash: expected ErrChanged, got %v", err)
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+
+	var missing *MissingError
+	if _, err := hashed.Check(path); !errors.As(err, &missing) || missing.Path != path {
+		t.Errorf("removed file: expected MissingError for %q, got %v", path, err)
+	}
+}
+
+func TestFingerprintRacy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.h")
+	if err := os.WriteFile(path, []byte("#define A 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fp, err := Stat(path, true)
+	if err != nil {
+		t.Fatal("cannot stat:", err)
+	}
+
+	if !fp.Racy(fp.ModTime) {
+		t.Error("fingerprint taken at the modification time isn't racy")
+	}
+	if !fp.Racy(fp.ModTime.Add(-time.Hour)) {
+		t.Error("modification time in the future isn't racy")
+	}
+	if fp.Racy(fp.ModTime.Add(time.Hour)) {
+		t.Error("fingerprint taken long after the modification time is racy")
+	}
+
+	// Change the content within the same timestamp.
+	if err := os.WriteFile(path, []byte("#define A 2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, fp.ModTime, fp.ModTime); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := fp.Check(path); err != nil {
+		t.Errorf("Check: unexpected error: %v", err)
+	}
+	if _, err := fp.CheckContent(path); !errors.Is(err, ErrChanged) {
+		t.Errorf("CheckContent: expected ErrChanged, got %v", err)
+	}
+}
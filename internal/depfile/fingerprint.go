@@ -0,0 +1,148 @@
+package depfile
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// ErrChanged is returned if a file doesn't match its fingerprint anymore.
+var ErrChanged = errors.New("file changed")
+
+// MissingError is returned if a dependency doesn't exist anymore.
+type MissingError struct {
+	Path string
+	Err  error
+}
+
+func (err *MissingError) Error() string {
+	return "missing dependency " + err.Path
+}
+
+func (err *MissingError) Unwrap() error {
+	return err.Err
+}
+
+// statErr wraps the error of stat'ing the file at the given path into a
+// MissingError if the file doesn't exist.
+func statErr(path string, err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return &MissingError{Path: path, Err: err}
+	}
+	return err
+}
+
+// Fingerprint describes the state of a file at some point.
+type Fingerprint struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	// Hash is the hex SHA-256 of the file's content, or an empty string if the
+	// content wasn't hashed.
+	Hash string `json:"hash,omitempty"`
+}
+
+// Stat returns the fingerprint of the file at the given path. The content is
+// only hashed if hash is true. A *MissingError is returned if the file doesn't
+// exist.
+func Stat(path string, hash bool) (Fingerprint, error) {
+	s, err := os.Stat(path)
+	if err != nil {
+		return Fingerprint{}, statErr(path, err)
+	}
+
+	fp := Fingerprint{
+		Size:    s.Size(),
+		ModTime: s.ModTime(),
+	}
+
+	if hash {
+		fp.Hash, err = hashFile(path)
+		if err != nil {
+			return Fingerprint{}, statErr(path, err)
+		}
+	}
+
+	return fp, nil
+}
+
+// RacyWindow is how close to the time that a fingerprint is taken the
+// modification time of the file must be for the fingerprint to be racy. It
+// covers the coarsest timestamp granularity of common file systems.
+const RacyWindow = 2 * time.Second
+
+// Racy returns true if the fingerprint was taken at the given time so soon
+// after the file was modified that the file may be modified again without its
+// modification time changing. Modification times in the future, which come
+// from clock skew, are racy too. Like in Git's index, only the content can
+// tell if such a file changed.
+func (fp Fingerprint) Racy(taken time.Time) bool {
+	return !fp.ModTime.Before(taken.Add(-RacyWindow))
+}
+
+// Check checks that the file at the given path still matches the fingerprint.
+// If only the modification time changed and the fingerprint has a hash, then
+// the content is hashed and compared instead, in which case the returned
+// Fingerprint has the new modification time. An error wrapping ErrChanged is
+// returned if the file doesn't match, and a *MissingError is returned if it
+// doesn't exist anymore.
+func (fp Fingerprint) Check(path string) (Fingerprint, error) {
+	return fp.check(path, false)
+}
+
+// CheckContent checks that the file at the given path still matches the
+// fingerprint by its content, even if its modification time didn't change.
+// This is what racy fingerprints need. A fingerprint without a hash never
+// matches.
+func (fp Fingerprint) CheckContent(path string) (Fingerprint, error) {
+	return fp.check(path, true)
+}
+
+func (fp Fingerprint) check(path string, content bool) (Fingerprint, error) {
+	now, err := Stat(path, false)
+	if err != nil {
+		return fp, err
+	}
+
+	if now.Size != fp.Size {
+		return fp, fmt.Errorf("%s: %w", path, ErrChanged)
+	}
+
+	if now.ModTime.Equal(fp.ModTime) && !content {
+		return fp, nil
+	}
+
+	if fp.Hash == "" {
+		return fp, fmt.Errorf("%s: %w", path, ErrChanged)
+	}
+
+	now.Hash, err = hashFile(path)
+	if err != nil {
+		return fp, statErr(path, err)
+	}
+
+	if now.Hash != fp.Hash {
+		return fp, fmt.Errorf("%s: %w", path, ErrChanged)
+	}
+
+	return now, nil
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
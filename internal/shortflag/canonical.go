@@ -0,0 +1,72 @@
+package shortflag
+
+// Arg describes a single argument in the order that it's given.
+type Arg struct {
+	// Name is the flag name including the prefixing dashes, or an empty string
+	// if the argument isn't a flag listed inside Opts.
+	Name string
+	// Value is the flag value, or the argument itself if Name is empty.
+	Value string
+
+	kind flagType
+	eq   bool
+}
+
+// IsFlag returns true if the argument is a flag, including the ones that
+// aren't listed inside Opts.
+func (a Arg) IsFlag() bool { return a.Name != "" || IsFlag(a.Value) }
+
+// String returns the canonical spelling of the argument. Single-dash flags
+// have their value joined right after, like -Ifoo, and long flags have it
+// joined with a "=", like --sysroot=foo. So do the flags whose Table only
+// allows that, like -std=c99. The compiler doesn't necessarily accept the
+// spelling, and it doesn't always identify the flag either: -Ttext 0x1000 and
+// -T text0x1000 are both spelled -Ttext0x1000. Use Fields to tell them apart.
+func (a Arg) String() string {
+	switch a.kind {
+	case blankFlag:
+		return a.Name
+	case valueFlag:
+		if a.eq {
+			return a.Name + "=" + a.Value
+		}
+		return a.Name + a.Value
+	default:
+		return a.Value
+	}
+}
+
+// Fields returns the flag name and its value as separate strings, or only the
+// name if the flag has no value, or the argument itself if it isn't a flag
+// listed inside Opts. Unlike String, different arguments never have the same
+// fields.
+func (a Arg) Fields() []string {
+	switch a.kind {
+	case blankFlag:
+		return []string{a.Name}
+	case valueFlag:
+		return []string{a.Name, a.Value}
+	default:
+		return []string{a.Value}
+	}
+}
+
+// Canonical parses the given list of arguments like Parse, but keeps every
+// argument in the order that it's given, so that the order of flags like -I,
+// -D and -U is kept. The arguments that are spelled differently but mean the
+// same, like -I foo and -Ifoo or --sysroot foo and --sysroot=foo, have the same
+// String. The function will always return the arguments that it has parsed.
+func Canonical(args []string, opts Opts) ([]Arg, error) {
+	canon := make([]Arg, 0, len(args))
+	err := scan(args, opts, func(arg Arg) { canon = append(canon, arg) })
+	return canon, err
+}
+
+// Strings returns the canonical spelling of each argument.
+func Strings(args []Arg) []string {
+	strs := make([]string, len(args))
+	for i, arg := range args {
+		strs[i] = arg.String()
+	}
+	return strs
+}
@@ -0,0 +1,156 @@
+package shortflag
+
+// Form describes the ways that a flag can be given its value. A flag without
+// any form is a blank flag.
+type Form uint8
+
+const (
+	// Joined is a value right after the flag, like -Ifoo.
+	Joined Form = 1 << iota
+	// Separate is a value in the next argument, like -I foo.
+	Separate
+	// JoinedEq is a value joined with a "=", like --sysroot=foo.
+	JoinedEq
+)
+
+// Blank is the form of a flag that has no value.
+const Blank Form = 0
+
+// Table maps flag names including the prefixing dashes to their forms.
+type Table map[string]Form
+
+// lookup looks up the flag that the argument spells. Like GCC, the longest
+// flag name that matches wins, so -isystem isn't taken for -i with a value.
+func (t Table) lookup(arg string) (flag, value string, kind flagType, joined, ok bool) {
+	for n := len(arg); n > 1; n-- {
+		name := arg[:n]
+
+		form, found := t[name]
+		if !found {
+			continue
+		}
+
+		if n == len(arg) {
+			if form == Blank {
+				return name, "", blankFlag, false, true
+			}
+			if form&Separate != 0 {
+				return name, "", valueFlag, false, true
+			}
+			// The flag can only be joined, so its value is empty.
+			return name, "", valueFlag, true, true
+		}
+
+		rest := arg[n:]
+
+		switch {
+		case form&JoinedEq != 0 && rest[0] == '=':
+			return name, rest[1:], valueFlag, true, true
+		case form&Joined != 0 && numDashes(name) == 1:
+			return name, rest, valueFlag, true, true
+		}
+	}
+
+	return "", "", notFlag, false, false
+}
+
+// spellEq returns true if the value of the flag is canonically joined with a
+// "=", which is the case for long flags and for flags that cannot be joined
+// otherwise, like -std=.
+func (t Table) spellEq(name string) bool {
+	if numDashes(name) > 1 {
+		return true
+	}
+	form := t[name]
+	return form&JoinedEq != 0 && form&Joined == 0
+}
+
+// GCC is the table of the options of GCC and Clang that take a value, along
+// with the blank options that would otherwise be taken for one of them with a
+// joined value. Options that are only ever joined, like -Wl, and -std=, don't
+// need to be listed, since they're a single argument anyway.
+var GCC = Table{
+	// Output and language.
+	"-o": Joined | Separate,
+	"-x": Joined | Separate,
+
+	// Preprocessor.
+	"-D":                  Joined | Separate,
+	"-U":                  Joined | Separate,
+	"-I":                  Joined | Separate,
+	"-A":                  Joined | Separate,
+	"-include":            Joined | Separate,
+	"-include-pch":        Separate,
+	"-imacros":            Joined | Separate,
+	"-isystem":            Joined | Separate,
+	"-isystem-after":      Joined | Separate,
+	"-iquote":             Joined | Separate,
+	"-idirafter":          Joined | Separate,
+	"-iprefix":            Joined | Separate,
+	"-iwithprefix":        Joined | Separate,
+	"-iwithprefixbefore":  Joined | Separate,
+	"-isysroot":           Joined | Separate,
+	"-imultilib":          Joined | Separate,
+	"-imultiarch":         Joined | Separate,
+	"-iframework":         Joined | Separate,
+	"-ivfsoverlay":        Joined | Separate,
+	"-F":                  Joined | Separate,
+	"-undef":              Blank,
+	"-MF":                 Joined | Separate,
+	"-MT":                 Joined | Separate,
+	"-MQ":                 Joined | Separate,
+	"-MJ":                 Joined | Separate,
+	"-Xpreprocessor":      Separate,
+	"--include-directory": Separate | JoinedEq,
+	"--define-macro":      Separate | JoinedEq,
+	"--undefine-macro":    Separate | JoinedEq,
+	"--include":           Separate | JoinedEq,
+	"--imacros":           Separate | JoinedEq,
+	"--assert":            Separate | JoinedEq,
+
+	// Linker.
+	"-L":                  Joined | Separate,
+	"-l":                  Joined | Separate,
+	"-T":                  Joined | Separate,
+	"-Tbss":               Separate,
+	"-Tdata":              Separate,
+	"-Ttext":              Separate,
+	"-u":                  Joined | Separate,
+	"-e":                  Joined | Separate,
+	"-z":                  Joined | Separate,
+	"-rpath":              Separate,
+	"-framework":          Separate,
+	"-install_name":       Separate,
+	"-Xlinker":            Separate,
+	"--library-directory": Separate | JoinedEq,
+	"--for-linker":        Separate | JoinedEq,
+	"--force-link":        Separate | JoinedEq,
+
+	// Driver.
+	"-B":                     Joined | Separate,
+	"-specs":                 Separate | JoinedEq,
+	"-wrapper":               Separate,
+	"-aux-info":              Separate,
+	"-dumpbase":              Separate,
+	"-dumpbase-ext":          Separate,
+	"-dumpdir":               Separate,
+	"-std":                   JoinedEq,
+	"-target":                Separate,
+	"-arch":                  Separate,
+	"-gcc-toolchain":         Separate,
+	"-resource-dir":          Separate | JoinedEq,
+	"-working-directory":     Separate | JoinedEq,
+	"-serialize-diagnostics": Separate,
+	"-Xassembler":            Separate,
+	"-Xclang":                Separate,
+	"-mllvm":                 Separate,
+	"--sysroot":              Separate | JoinedEq,
+	"--output":               Separate | JoinedEq,
+	"--language":             Separate | JoinedEq,
+	"--param":                Separate | JoinedEq,
+	"--prefix":               Separate | JoinedEq,
+	"--std":                  JoinedEq,
+	"--target":               JoinedEq,
+	"--gcc-toolchain":        JoinedEq,
+	"--config":               Separate | JoinedEq,
+}
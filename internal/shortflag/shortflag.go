@@ -48,6 +48,9 @@ func (f *Flag) addValue(value string) {
 type Opts struct {
 	ValueFlags []string
 	BlankFlags []string
+	// Table describes flags along with their forms, like GCC. It's looked up
+	// before ValueFlags and BlankFlags.
+	Table Table
 }
 
 type flagType uint8
@@ -58,11 +61,11 @@ const (
 	blankFlag
 )
 
-func (o Opts) lookupFlag(arg string) flagType {
-	if findStr(o.ValueFlags, arg) > -1 {
+func (o Opts) lookupFlag(flag string) flagType {
+	if findStr(o.ValueFlags, flag) > -1 {
 		return valueFlag
 	}
-	if findStr(o.BlankFlags, arg) > -1 {
+	if findStr(o.BlankFlags, flag) > -1 {
 		return blankFlag
 	}
 	return notFlag
@@ -77,14 +80,22 @@ func Omit(args []string, opts Opts) []string {
 	return f.Args
 }
 
-// NonFlags omits all arguments that start with a dash.
+// Stdin is the argument that names the standard input as an input file.
+const Stdin = "-"
+
+// IsFlag returns true if the argument starts with a dash and isn't Stdin.
+func IsFlag(arg string) bool {
+	return strings.HasPrefix(arg, "-") && arg != Stdin
+}
+
+// NonFlags omits all flags, keeping Stdin.
 func NonFlags(args []string) []string {
-	return filter(args, func(v string) bool { return !strings.HasPrefix(v, "-") })
+	return filter(args, func(v string) bool { return !IsFlag(v) })
 }
 
-// OmitNonFlags omits all arguments that don't start with a dash.
+// OmitNonFlags omits all arguments that aren't flags, including Stdin.
 func OmitNonFlags(args []string) []string {
-	return filter(args, func(v string) bool { return strings.HasPrefix(v, "-") })
+	return filter(args, IsFlag)
 }
 
 func filter(args []string, f func(string) bool) []string {
@@ -105,59 +116,126 @@ func Parse(args []string, opts Opts) (*Flags, error) {
 		Flags: make([]Flag, 0, len(opts.ValueFlags)+len(opts.BlankFlags)),
 	}
 
+	err := scan(args, opts, func(arg Arg) {
+		switch arg.kind {
+		case notFlag:
+			f.Args = append(f.Args, arg.Value)
+		case blankFlag:
+			f.addFlag(arg.Name)
+		case valueFlag:
+			f.addFlag(arg.Name).addValue(arg.Value)
+		}
+	})
+
+	return &f, err
+}
+
+// scan parses the given list of arguments and calls fn with each of them in
+// order.
+func scan(args []string, opts Opts, fn func(Arg)) error {
 	var currentFlag string
 
 	for _, arg := range args {
 		if currentFlag != "" {
-			f.addFlag(currentFlag).addValue(arg)
+			fn(Arg{Name: currentFlag, Value: arg, kind: valueFlag, eq: opts.spellEq(currentFlag)})
 			currentFlag = ""
 			continue
 		}
 
-		if !strings.HasPrefix(arg, "-") {
-			f.Args = append(f.Args, arg)
+		if !IsFlag(arg) {
+			fn(Arg{Value: arg})
 			continue
 		}
 
-		numDashes := numDashes(arg)
-		flag := arg
+		flag, value, kind, joined := opts.lookupArg(arg)
 
-		if numDashes == 1 && len(flag) > len("-X") {
-			// Ensure that we're looking up the exact shortflag's name.
-			flag = flag[:2]
-		}
-
-		switch opts.lookupFlag(flag) {
+		switch kind {
 		case notFlag:
-			f.Args = append(f.Args, arg)
+			fn(Arg{Value: arg})
 		case blankFlag:
-			f.addFlag(flag)
+			fn(Arg{Name: flag, kind: blankFlag})
 		case valueFlag:
-			switch numDashes {
-			case 0:
-				panic("unreachable")
-			case 1:
-				// Single-dash, so the value can be right after. We can just do
-				// a length check.
-				if len(arg) > len("-X") {
-					// Value is right after.
-					f.addFlag(flag).addValue(arg[2:])
-				} else {
-					currentFlag = arg
-				}
-			default:
-				// Definitely a long flag, meaning that the value might be on
-				// the next value.
-				currentFlag = arg
+			if joined {
+				fn(Arg{Name: flag, Value: value, kind: valueFlag, eq: opts.spellEq(flag)})
+			} else {
+				// The value is the next argument.
+				currentFlag = flag
 			}
 		}
 	}
 
 	if currentFlag != "" {
-		return &f, fmt.Errorf("flag %q missing value", currentFlag)
+		return fmt.Errorf("flag %q missing value", currentFlag)
+	}
+
+	return nil
+}
+
+// lookupArg looks up the flag that the argument spells. If joined is true, then
+// the value is in the argument itself.
+func (o Opts) lookupArg(arg string) (flag, value string, kind flagType, joined bool) {
+	if o.Table != nil {
+		if flag, value, kind, joined, ok := o.Table.lookup(arg); ok {
+			return flag, value, kind, joined
+		}
+	}
+
+	if numDashes(arg) > 1 {
+		// Long flags may have their value joined with a "=".
+		flag = arg
+		if i := strings.IndexByte(arg, '='); i > -1 {
+			flag, value, joined = arg[:i], arg[i+1:], true
+		}
+
+		kind = o.lookupFlag(flag)
+		if kind == blankFlag && joined {
+			return arg, "", notFlag, false
+		}
+
+		return flag, value, kind, joined
 	}
 
-	return &f, nil
+	if o.lookupFlag(arg) == blankFlag {
+		return arg, "", blankFlag, false
+	}
+
+	// Ensure that we're looking up the exact shortflag's name. Its value can be
+	// right after.
+	if len(arg) > len("-X") && o.lookupFlag(arg[:2]) == valueFlag {
+		return arg[:2], arg[2:], valueFlag, true
+	}
+
+	// Multi-letter flags like -isystem are looked up whole, and their value
+	// may also be right after, like -isystemdir.
+	if o.lookupFlag(arg) == valueFlag {
+		return arg, "", valueFlag, false
+	}
+	if flag := o.longestValueFlag(arg); flag != "" {
+		return flag, arg[len(flag):], valueFlag, true
+	}
+
+	return arg, "", notFlag, false
+}
+
+// spellEq returns true if the value of the flag is canonically joined with a
+// "=".
+func (o Opts) spellEq(flag string) bool {
+	if _, ok := o.Table[flag]; ok {
+		return o.Table.spellEq(flag)
+	}
+	return numDashes(flag) > 1
+}
+
+// longestValueFlag returns the longest single-dash value flag that prefixes the
+// argument, or an empty string if there's none.
+func (o Opts) longestValueFlag(arg string) string {
+	var longest string
+	for _, flag := range o.ValueFlags {
+		if numDashes(flag) == 1 && len(flag) > len(longest) && strings.HasPrefix(arg, flag) {
+			longest = flag
+		}
+	}
+	return longest
 }
 
 func numDashes(str string) int {
@@ -170,7 +248,7 @@ func numDashes(str string) int {
 
 func findStr(strv []string, find string) int {
 	for i, str := range strv {
-		if strings.HasPrefix(str, find) {
+		if str == find {
 			return i
 		}
 	}
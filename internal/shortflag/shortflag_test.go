@@ -15,6 +15,7 @@ func TestParse(t *testing.T) {
 		"--short",
 		"--unknown", "-u",
 		"argument3",
+		"-",
 	}
 
 	f, err := Parse(in, Opts{
@@ -26,11 +27,11 @@ func TestParse(t *testing.T) {
 	}
 
 	expect := &Flags{
-		Args: []string{"argument1", "argument2", "--unknown", "-u", "argument3"},
-		Flags: map[string][]string{
-			"--short": nil,
-			"--value": {"3"},
-			"-v":      {"1", "2"},
+		Args: []string{"argument1", "argument2", "--unknown", "-u", "argument3", "-"},
+		Flags: []Flag{
+			{Name: "-v", Values: []string{"1", "2"}},
+			{Name: "--value", Values: []string{"3"}},
+			{Name: "--short"},
 		},
 	}
 
@@ -39,3 +40,112 @@ func TestParse(t *testing.T) {
 		t.Errorf("got:      %#v", f)
 	}
 }
+
+func TestNonFlags(t *testing.T) {
+	in := []string{"-c", "-x", "c", "-", "-o", "/dev/null"}
+
+	if got, expect := NonFlags(in), []string{"c", "-", "/dev/null"}; !reflect.DeepEqual(expect, got) {
+		t.Errorf("NonFlags: expected %q, got %q", expect, got)
+	}
+
+	if got, expect := OmitNonFlags(in), []string{"-c", "-x", "-o"}; !reflect.DeepEqual(expect, got) {
+		t.Errorf("OmitNonFlags: expected %q, got %q", expect, got)
+	}
+}
+
+func TestCanonical(t *testing.T) {
+	opts := Opts{
+		ValueFlags: []string{"-o", "-I", "-D", "-U", "-isystem", "--sysroot"},
+		BlankFlags: []string{"-c"},
+	}
+
+	spellings := [][]string{
+		{"-c", "-I", "foo", "-DX", "-U", "X", "-isystem", "bar", "--sysroot=root", "-O2", "a.c"},
+		{"-c", "-Ifoo", "-D", "X", "-UX", "-isystembar", "--sysroot", "root", "-O2", "a.c"},
+	}
+
+	expect := []string{"-c", "-Ifoo", "-DX", "-UX", "-isystembar", "--sysroot=root", "-O2", "a.c"}
+
+	for _, args := range spellings {
+		canon, err := Canonical(args, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := Strings(canon); !reflect.DeepEqual(expect, got) {
+			t.Errorf("%q: expected %q, got %q", args, expect, got)
+		}
+	}
+
+	// -D and -U must not be reordered, since the last one wins.
+	canon, _ := Canonical([]string{"-UX", "-D", "X"}, opts)
+	if got, expect := Strings(canon), []string{"-UX", "-DX"}; !reflect.DeepEqual(expect, got) {
+		t.Errorf("expected %q, got %q", expect, got)
+	}
+}
+
+func TestGCC(t *testing.T) {
+	in := []string{
+		"-c", "-I", "/usr/include/gtk-3.0", "-include", "foo.h",
+		"-isystemsys", "-std=c99", "--sysroot", "root", "-undef",
+		"-Ttext", "0x1000", "-Wl,-z,now", "-MF", "a.d", "a.c",
+	}
+
+	canon, err := Canonical(in, Opts{Table: GCC})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []string{
+		"-c", "-I/usr/include/gtk-3.0", "-includefoo.h",
+		"-isystemsys", "-std=c99", "--sysroot=root", "-undef",
+		"-Ttext0x1000", "-Wl,-z,now", "-MFa.d", "a.c",
+	}
+
+	if got := Strings(canon); !reflect.DeepEqual(expect, got) {
+		t.Errorf("expected: %q", expect)
+		t.Errorf("got:      %q", got)
+	}
+
+	f, err := Parse(in, Opts{Table: GCC})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, expect := NonFlags(f.Args), []string{"a.c"}; !reflect.DeepEqual(expect, got) {
+		t.Errorf("inputs: expected %q, got %q", expect, got)
+	}
+}
+
+func TestFields(t *testing.T) {
+	// Each pair is spelled the same by String, but must have different fields.
+	pairs := [][2][]string{
+		{{"-Ttext", "0x1000"}, {"-T", "text0x1000"}},
+		{{"-u", "ndef"}, {"-undef"}},
+		{{"-isystem-after", "foo"}, {"-isystem", "-afterfoo"}},
+	}
+
+	fields := func(args []string) []string {
+		canon, err := Canonical(args, Opts{Table: GCC})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var fields []string
+		for _, arg := range canon {
+			fields = append(fields, arg.Fields()...)
+		}
+		return fields
+	}
+
+	for _, pair := range pairs {
+		a, b := fields(pair[0]), fields(pair[1])
+		if reflect.DeepEqual(a, b) {
+			t.Errorf("%q and %q have the same fields %q", pair[0], pair[1], a)
+		}
+	}
+
+	if got, expect := fields([]string{"-I", "foo", "-c"}), fields([]string{"-Ifoo", "-c"}); !reflect.DeepEqual(expect, got) {
+		t.Errorf("expected %q, got %q", expect, got)
+	}
+}
@@ -2,10 +2,8 @@ package main
 
 import (
 	"bytes"
-	"crypto/sha256"
-	"encoding/base64"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"os"
 	"os/exec"
@@ -15,22 +13,27 @@ import (
 
 	"github.com/diamondburned/cgowrap/internal/cgowrap"
 	"github.com/diamondburned/cgowrap/internal/csvfile"
+	"github.com/diamondburned/cgowrap/internal/depfile"
 	"github.com/diamondburned/cgowrap/internal/logg"
-	"github.com/diamondburned/cgowrap/internal/shortflag"
 )
 
 type state struct {
 	args      []string
-	input     []byte
+	env       []string
+	inv       *cgowrap.Invocation
+	kind      cgowrap.Classifier
 	cache     cacheState
 	cacheable bool
 }
 
 type cacheState struct {
 	*cgowrap.Cache
+	output      *cgowrap.OutputCache
+	outputs     map[string]string
 	depfileKey  string
 	depfilePath string
-	cacheKey    string
+	cacheKey    *cgowrap.Key
+	compiler    cgowrap.Compiler
 }
 
 var pwd, _ = os.Getwd()
@@ -39,11 +42,17 @@ var (
 	profileOut = os.Getenv("CGOWRAP_PROFILE")
 	runFatal   = os.Getenv("CGOWRAP_FATAL") == "1"
 	mustCache  = os.Getenv("CGOWRAP_MUST_CACHE") == "1"
+	depLog     = os.Getenv(cgowrap.DepLogEnv)
+	driver     = cgowrap.DriverFromName(os.Args[0])
 )
 
 func main() {
 	logg.SetEnabled(runFatal || mustCache)
 
+	if len(os.Args) > 1 && os.Args[1] == "toolexec" {
+		os.Exit(toolexec(os.Args[2:]))
+	}
+
 	out := run()
 	out.Print()
 	os.Exit(out.Status)
@@ -56,15 +65,19 @@ func run() cgowrap.Output {
 
 	var out cgowrap.Output
 
-	f := func() bool {
+	f := func() string {
 		o, ok := s.cached()
-		if ok {
+		if ok && sampleVerify() {
+			out = s.verify(o)
+			return statusVerified
+		}
+		if ok && s.restore(o) {
 			out = o
-			return false
+			return statusCached
 		}
 
 		out = s.run()
-		return true
+		return statusUncached
 	}
 
 	if profileOut != "" {
@@ -73,44 +86,69 @@ func run() cgowrap.Output {
 		f()
 	}
 
+	if depLog != "" {
+		s.logDeps()
+	}
+
 	return out
 }
 
-func (s *state) record(f func() bool) {
+// The statuses of the invocations in the profile.
+const (
+	statusCached   = "cached"
+	statusUncached = "uncached"
+	// statusVerified is a cache hit that the compiler was still ran for, to
+	// verify the cached output.
+	statusVerified = "verified"
+)
+
+func (s *state) record(f func() string) {
 	start := time.Now()
-	status := "cached"
-	if f() {
-		status = "uncached"
+	status := f()
+
+	var kind string
+	if s.kind != nil {
+		kind = s.kind.Name()
 	}
+
+	record(s.args, start, status, kind)
+}
+
+// record writes a row into the profile.
+func record(args []string, start time.Time, status, kind string) {
 	end := time.Now()
 
 	csvfile.Write(profileOut,
-		strings.Join(s.args, " "),
+		strings.Join(args, " "),
 		strconv.FormatFloat(end.Sub(start).Seconds(), 'f', -1, 64),
 		status,
+		kind,
 	)
 }
 
 func (s *state) init() {
-	// isGuessKinds relies on the assumption that when the check passes, only
-	// one input will ever be given, which is cgo's crafted input file. This
-	// assumption simplifies the code a lot.
+	s.inv = cgowrap.NewInvocation(driver, s.args, pwd, os.Stdin)
 
-	input, err := os.ReadFile(s.args[len(s.args)-1])
-	if err != nil {
-		// Probably an incorrect assumption about arguments.
+	s.kind = cgowrap.Classify(s.inv)
+	if s.kind == nil {
 		return
 	}
 
-	if !isGuessKinds(input) {
-		return
-	}
+	logg.Debug("classified as", s.kind.Name())
 
 	if !s.openCache() {
 		return
 	}
 
-	s.input = input
+	if base := s.cache.BaseDir(); !base.IsZero() && !cgowrap.Relocatable(s.kind, s.inv, base.Dir) {
+		logg.Debug("not relocating, since the outputs name the base directory")
+		s.cache.DropBaseDir()
+	}
+
+	work := cgowrap.FindWorkDirs(append([]string{pwd}, s.args...)...)
+	work.SetObjdir(s.inv.Output())
+	s.cache.SetWorkDirs(work)
+	s.cache.output = s.cache.Outputs(s.kind.Bucket())
 	s.cacheable = true
 	return
 }
@@ -121,40 +159,98 @@ func (s *state) cached() (cgowrap.Output, bool) {
 		return cgowrap.Output{}, false
 	}
 
-	// Parse never returns nil.
-	args, err := shortflag.Parse(s.args, shortflag.Opts{
-		ValueFlags: []string{"-o"},
-	})
+	material, err := s.kind.Key(s.inv)
 	if err != nil {
-		logg.DebugFatalErr("error parsing flags:", err)
+		logg.DebugFatalErr("cannot get key material:", err)
 		return cgowrap.Output{}, false
 	}
 
-	// Ues the arguments without the -o flag and all input files. The input file
-	// is assumed to only be 1, and the -o flag is not deterministic.
-	neededArgs := shortflag.OmitNonFlags(args.Args)
-	// Use neededArgs as the hash input for depfileKey along with the input
-	// file's content and the current working directory.
-	hash := hashAll([]interface{}{neededArgs, pwd, s.input})
-	s.cache.depfileKey = fmt.Sprintf("cgo.%s.d", hash)
-
-	if err := s.cache.Depfile.Validate(s.cache.depfileKey); err != nil {
-		// Depfile not found, so avoid this cache and ask for a new one.
-		s.args = append([]string{"-MD", "-MF", s.cache.Depfile.Path(s.cache.depfileKey)}, s.args...)
-		cacheMissed(neededArgs, hash, "invalid depfile:", err)
+	cc, err := s.cache.Compiler(s.inv.Driver)
+	if err != nil {
+		logg.DebugFatalErr("cannot identify compiler:", err)
+		return cgowrap.Output{}, false
+	}
+
+	logg.Debug("compiler:", cc.ID)
+	s.cache.compiler = cc
+
+	libs, err := s.cache.LibraryMaterial(s.kind, s.inv, cc)
+	if err != nil {
+		logg.DebugFatalErr("cannot get library fingerprints:", err)
 		return cgowrap.Output{}, false
 	}
+	material = append(material, libs...)
+
+	// The output files are not part of the key, but they are part of the
+	// output, so remember where they go.
+	s.cache.outputs = s.kind.Outputs(s.inv)
+	s.cache.output.SetIOPaths(cgowrap.NewIOPaths(s.inv.InputNames(), s.cache.outputs))
+
+	// The driver and the languages keep the C and C++ compiles of the same
+	// input apart.
+	material = append(material, cc.ID, cc.Env(), string(s.inv.Driver), s.inv.Languages())
+
+	material = s.cache.KeyMaterial(material)
+
+	ns := s.cache.Namespace(s.kind, s.inv)
+	s.cache.cacheKey = cgowrap.NewKey(ns, s.kind.Name(), material...)
+	hash := s.cache.cacheKey.Hash()
+
+	if s.kind.Depfile() {
+		s.cache.depfileKey = s.cache.cacheKey.ID() + ".d"
+
+		if err := s.cache.Depfile.Validate(s.cache.depfileKey); err != nil {
+			// Depfile not found, so avoid this cache and ask for a new one.
+			s.requestDepfile(s.cache.Depfile.Path(s.cache.depfileKey))
+			depfileMissed(s.inv.Args, hash, err)
+			return cgowrap.Output{}, false
+		}
+	}
 
 	// We'll only check the cached output if our depfile is up to date. We don't
 	// need to account for this in the input hash, though.
-	out, ok := s.cache.GuessKinds.Load(s.cache.depfileKey)
-	if ok {
+	out, err := s.cache.output.Load(s.cache.cacheKey)
+	if err == nil {
 		return out, true
 	}
-	cacheMissed(neededArgs, hash, "missing guessKinds")
+	outputMissed(s.inv.Args, hash, err)
 	return cgowrap.Output{}, false
 }
 
+// requestDepfile makes the compiler write its dependencies into the depfile at
+// the given path. gcc overwrites the -MF file for every source, so invocations
+// with several sources use SUNPRO_DEPENDENCIES instead, which gcc appends a
+// target to for every source.
+func (s *state) requestDepfile(path string) {
+	if len(s.inv.SourceNames()) < 2 {
+		s.args = append([]string{"-MD", "-MF", path}, s.args...)
+		return
+	}
+
+	// Start afresh, since the file is appended to.
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		logg.DebugFatalErr("cannot remove old depfile:", err)
+	}
+
+	s.env = append(os.Environ(), "SUNPRO_DEPENDENCIES="+path)
+}
+
+// logDeps appends the dependencies of the invocation to the dependency log of
+// the cgo run that it's a part of.
+func (s *state) logDeps() {
+	var files, absent []string
+	known := s.cacheable
+
+	if known && s.kind.Depfile() {
+		var err error
+		files, absent, err = s.cache.Depfile.Files(s.cache.depfileKey)
+		known = err == nil
+	}
+
+	err := cgowrap.AppendDepLog(depLog, files, absent, known)
+	logg.DebugFatalErr("cannot write dependency log:", err)
+}
+
 func cacheMissed(args []string, hash string, v ...interface{}) {
 	if mustCache {
 		log.Printf("args: %q", args)
@@ -164,6 +260,29 @@ func cacheMissed(args []string, hash string, v ...interface{}) {
 	}
 }
 
+// depfileMissed reports a cache miss caused by an invalid depfile. A missing
+// dependency is reported by name.
+func depfileMissed(args []string, hash string, err error) {
+	var missing *depfile.MissingError
+	if errors.As(err, &missing) {
+		cacheMissed(args, hash, "missing dependency:", missing.Path)
+		return
+	}
+	cacheMissed(args, hash, "invalid depfile:", err)
+}
+
+// outputMissed reports a cache miss caused by an output that cannot be loaded.
+// An output saved with different key material means that the key is broken, so
+// it's fatal in debug mode.
+func outputMissed(args []string, hash string, err error) {
+	if errors.Is(err, cgowrap.ErrKeyMismatch) {
+		logg.DebugFatalErr("cached output doesn't match its key:", err)
+		cacheMissed(args, hash, err)
+		return
+	}
+	cacheMissed(args, hash, "missing output")
+}
+
 // openCache initializes the cache.
 func (s *state) openCache() bool {
 	if s.cache.Cache != nil {
@@ -176,95 +295,79 @@ func (s *state) openCache() bool {
 		return true
 	}
 
-	logg.DebugFatalErr("cannot open cache database:", err)
+	openCacheFailed(err)
 	return false
 }
 
+// openCacheFailed reports why the cache cannot be opened. A cache written by a
+// newer cgowrap is always warned about, since nothing is cached until it's
+// upgraded.
+func openCacheFailed(err error) {
+	if errors.Is(err, cgowrap.ErrNewerSchema) {
+		fmt.Fprintf(os.Stderr, "cgowrap: warning: %v; running uncached\n", err)
+		return
+	}
+	logg.DebugFatalErr("cannot open cache database:", err)
+}
+
 func (s *state) close() {
 }
 
+// restore writes the output files of a cached invocation to where the current
+// invocation wants them. It returns false if the files cannot be restored, in
+// which case the compiler should be run instead.
+func (s *state) restore(out cgowrap.Output) bool {
+	if err := out.WriteFiles(s.cache.outputs); err != nil {
+		logg.DebugFatalErr("cannot restore output files:", err)
+		return false
+	}
+	return true
+}
+
 // run runs the compiler and caches it if available.
 func (s *state) run() cgowrap.Output {
+	out := s.exec()
+	s.save(out)
+	return out
+}
+
+// exec runs the compiler.
+func (s *state) exec() cgowrap.Output {
 	var stdout, stderr bytes.Buffer
 
-	cmd := exec.Command(CC(), s.args...)
-	cmd.Stdin = os.Stdin
+	cmd := exec.Command(s.inv.Driver.Compiler(), s.args...)
+	cmd.Env = s.env
+	cmd.Stdin = s.inv.Stdin()
 	cmd.Stderr = &stderr
 	cmd.Stdout = &stdout
 	cmd.Run()
 
-	out := cgowrap.Output{
+	return cgowrap.Output{
 		Stdout: stdout.Bytes(),
 		Stderr: stderr.Bytes(),
 		Status: cmd.ProcessState.ExitCode(),
 	}
-
-	s.save(out)
-	return out
 }
 
 func (s *state) save(out cgowrap.Output) {
-	if !s.cacheable || s.cache.depfileKey == "" {
+	if !s.cacheable || s.cache.cacheKey == nil {
 		return
 	}
 
 	var err error
 
-	err = s.cache.Depfile.Save(s.cache.depfileKey)
-	logg.DebugFatalErr("cannot save depfile:", err)
-
-	err = s.cache.GuessKinds.Save(s.cache.depfileKey, out)
-	logg.DebugFatalErr("cannot save guessKinds:", err)
-}
-
-func CC() string {
-	return envOr("CGOWRAP_CC", envOr("GCC", "gcc"))
-}
-
-func envOr(env, or string) string {
-	if v := os.Getenv(env); v != "" {
-		return v
+	if s.cache.depfileKey != "" {
+		dirs := s.cache.SearchPath(s.inv, s.cache.compiler)
+		err = s.cache.Depfile.Save(s.cache.depfileKey, dirs, s.inv.SourceNames()...)
+		logg.DebugFatalErr("cannot save depfile:", err)
 	}
-	return or
-}
-
-func hashStr(str string) string {
-	h := sha256.New()
-	return base64.URLEncoding.EncodeToString(h.Sum([]byte(str)))
-}
 
-func hashAll(v ...interface{}) string {
-	h := sha256.New()
-	for _, v := range v {
-		switch v := v.(type) {
-		case string:
-			io.WriteString(h, v)
-		case []string:
-			for _, v := range v {
-				io.WriteString(h, v)
-			}
-		case []byte:
-			h.Write(v)
-		default:
-			fmt.Fprint(h, v)
-		}
+	err = out.ReadFiles(s.cache.outputs)
+	if err != nil {
+		logg.DebugFatalErr("cannot read output files:", err)
+		return
 	}
-	return base64.URLEncoding.EncodeToString(h.Sum(nil))
-}
-
-func isGuessKinds(stdin []byte) bool {
-	return bytesContainsLines(stdin,
-		`#line 1 "cgo-generated-wrapper"`,
-		`#line 1 "completed"`,
-		`int __cgo__1 = __cgo__2;`,
-	)
-}
 
-func bytesContainsLines(b []byte, strs ...string) bool {
-	for _, str := range strs {
-		if !bytes.Contains(b, []byte(str)) {
-			return false
-		}
-	}
-	return true
+	err = s.cache.output.Save(s.cache.cacheKey, out)
+	logg.DebugFatalErr("cannot save output:", err)
 }
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/diamondburned/cgowrap/internal/cgowrap"
+)
+
+func TestRequestDepfile(t *testing.T) {
+	single := []string{"-c", "a.c", "-o", "a.o"}
+	s := state{args: single, inv: cgowrap.NewInvocation(cgowrap.CCDriver, single, "/", nil)}
+	s.requestDepfile("/x.d")
+
+	if expect := append([]string{"-MD", "-MF", "/x.d"}, single...); !reflect.DeepEqual(expect, s.args) {
+		t.Errorf("expected %q, got %q", expect, s.args)
+	}
+	if s.env != nil {
+		t.Errorf("expected the environment to be inherited, got %q", s.env)
+	}
+
+	// gcc would overwrite -MF for every source.
+	several := []string{"-c", "a.c", "b.c"}
+	s = state{args: several, inv: cgowrap.NewInvocation(cgowrap.CCDriver, several, "/", nil)}
+	s.requestDepfile("/x.d")
+
+	if !reflect.DeepEqual(several, s.args) {
+		t.Errorf("expected %q, got %q", several, s.args)
+	}
+	if len(s.env) == 0 || s.env[len(s.env)-1] != "SUNPRO_DEPENDENCIES=/x.d" {
+		t.Errorf("expected SUNPRO_DEPENDENCIES to be set, got %q", s.env)
+	}
+}
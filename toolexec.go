@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/diamondburned/cgowrap/internal/cgowrap"
+	"github.com/diamondburned/cgowrap/internal/depfile"
+	"github.com/diamondburned/cgowrap/internal/logg"
+)
+
+// toolexec runs a tool for go build -toolexec="cgowrap toolexec". Whole cgo
+// runs are cached, and every other tool is ran as-is. The exit status is
+// returned.
+func toolexec(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: cgowrap toolexec <tool> [args...]")
+		return 2
+	}
+
+	tool, args := args[0], args[1:]
+	start := time.Now()
+
+	run, ok := cgowrap.ParseCgoRun(tool, args, pwd)
+	if !ok || !routeCompiler() {
+		return execTool(tool, args)
+	}
+
+	cache, err := cgowrap.OpenCache()
+	if err != nil {
+		openCacheFailed(err)
+		return execTool(tool, args)
+	}
+
+	if base := cache.BaseDir(); !base.IsZero() && !run.Relocatable(base.Dir) {
+		logg.Debug("not relocating, since the outputs name the base directory")
+		cache.DropBaseDir()
+	}
+
+	work := cgowrap.FindWorkDirs(append([]string{pwd}, args...)...)
+	work.SetObjdir(run.Objdir)
+	cache.SetWorkDirs(work)
+
+	material, err := run.Key()
+	if err != nil {
+		logg.DebugFatalErr("cannot get key material:", err)
+		return execTool(tool, args)
+	}
+
+	cc, err := cache.Compiler(cgowrap.CCDriver)
+	if err != nil {
+		logg.DebugFatalErr("cannot identify compiler:", err)
+		return execTool(tool, args)
+	}
+
+	logg.Debug("compiler:", cc.ID)
+	material = append(material, cc.ID, cc.Env())
+	material = cache.KeyMaterial(material)
+
+	// The identity of cgo is part of the material, so the cgo templates don't
+	// need their own namespace.
+	ns := cgowrap.Namespace{GoVersion: cgowrap.ToolchainVersion(run.Tool)}
+	key := cgowrap.NewKey(ns, cgowrap.CgoBucket, material...)
+	hash := key.Hash()
+	depfileKey := key.ID() + ".d"
+	outputs := cache.Outputs(cgowrap.CgoBucket)
+
+	if err := cache.Depfile.Validate(depfileKey); err != nil {
+		depfileMissed(args, hash, err)
+	} else if out, err := outputs.Load(key); err != nil {
+		outputMissed(args, hash, err)
+	} else if err := out.WriteFiles(run.RestorePaths(out)); err != nil {
+		logg.DebugFatalErr("cannot restore output files:", err)
+	} else {
+		// Whole cgo runs aren't verified with CGOWRAP_VERIFY. Only the
+		// compiles that cgo runs through the wrapper are, when the run isn't
+		// cached.
+		if profileOut != "" {
+			record(args, start, statusCached, cgowrap.CgoBucket)
+		}
+		out.Print()
+		return out.Status
+	}
+
+	depLog, err := os.CreateTemp("", "cgowrap-deplog-")
+	if err != nil {
+		logg.DebugFatalErr("cannot create dependency log:", err)
+		return execTool(tool, args)
+	}
+	depLog.Close()
+	defer os.Remove(depLog.Name())
+
+	snapshot := run.Snapshot()
+
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.Command(tool, args...)
+	cmd.Env = append(os.Environ(), cgowrap.DepLogEnv+"="+depLog.Name())
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.Run()
+
+	out := cgowrap.Output{
+		Stdout: stdout.Bytes(),
+		Stderr: stderr.Bytes(),
+		Status: cmd.ProcessState.ExitCode(),
+	}
+	out.Print()
+
+	if profileOut != "" {
+		record(args, start, statusUncached, cgowrap.CgoBucket)
+	}
+
+	// Only cache successful runs. A failed run may not have asked the compiler
+	// about everything, so its dependencies aren't complete.
+	if out.Status != 0 {
+		return out.Status
+	}
+
+	deps, absent, err := cgowrap.ReadDepLog(depLog.Name())
+	if err != nil {
+		logg.DebugFatalErr("cannot read dependency log:", err)
+		return out.Status
+	}
+
+	err = cache.Depfile.SaveFile(depfileKey, &depfile.File{
+		Sources: map[string]depfile.FileList{cgowrap.CgoBucket: deps},
+	}, absent)
+	if err != nil {
+		logg.DebugFatalErr("cannot save depfile:", err)
+		return out.Status
+	}
+
+	if err := out.ReadFiles(run.Outputs(snapshot)); err != nil {
+		logg.DebugFatalErr("cannot read output files:", err)
+		return out.Status
+	}
+
+	err = outputs.Save(key, out)
+	logg.DebugFatalErr("cannot save output:", err)
+
+	return out.Status
+}
+
+// routeCompiler makes cgo run its compiler through cgowrap, so that every
+// invocation logs its dependencies. The real compiler is moved into
+// CGOWRAP_CC. False is returned if that's not possible.
+func routeCompiler() bool {
+	self, err := os.Executable()
+	if err != nil {
+		return false
+	}
+
+	cc := os.Getenv("CC")
+	if cc == "" {
+		cc = os.Getenv("GCC")
+	}
+
+	if isSelf(cc, self) {
+		// Already routed. The real compiler must be in CGOWRAP_CC then.
+		return os.Getenv("CGOWRAP_CC") != ""
+	}
+
+	if os.Getenv("CGOWRAP_CC") == "" {
+		if cc == "" {
+			cc = cgowrap.CC()
+		}
+		if strings.ContainsAny(cc, " \t") {
+			// The compiler comes with flags, which CGOWRAP_CC cannot hold.
+			return false
+		}
+		os.Setenv("CGOWRAP_CC", cc)
+	}
+
+	os.Setenv("CC", self)
+	os.Unsetenv("GCC")
+	return true
+}
+
+// isSelf returns true if the command is cgowrap itself.
+func isSelf(cmd, self string) bool {
+	if cmd == "" {
+		return false
+	}
+
+	path, err := exec.LookPath(cmd)
+	if err != nil {
+		return false
+	}
+
+	s1, err1 := os.Stat(path)
+	s2, err2 := os.Stat(self)
+	return err1 == nil && err2 == nil && os.SameFile(s1, s2)
+}
+
+// execTool runs the tool as-is and returns its exit status.
+func execTool(tool string, args []string) int {
+	cmd := exec.Command(tool, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return exitErr.ExitCode()
+		}
+		fmt.Fprintln(os.Stderr, "cgowrap: cannot run", filepath.Base(tool)+":", err)
+		return 1
+	}
+
+	return 0
+}
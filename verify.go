@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/diamondburned/cgowrap/internal/cgowrap"
+	"github.com/diamondburned/cgowrap/internal/logg"
+)
+
+var (
+	verifyFraction = parseVerifyFraction(os.Getenv("CGOWRAP_VERIFY"))
+	verifyStrict   = os.Getenv("CGOWRAP_VERIFY_STRICT") == "1"
+	verifyRand     = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// parseVerifyFraction parses the share of cache hits to verify, which must be
+// between 0 and 1.
+func parseVerifyFraction(v string) float64 {
+	if v == "" {
+		return 0
+	}
+
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f < 0 || f > 1 {
+		fmt.Fprintf(os.Stderr, "cgowrap: invalid CGOWRAP_VERIFY %q, must be between 0 and 1\n", v)
+		return 0
+	}
+
+	return f
+}
+
+// sampleVerify returns true if the current cache hit should be verified.
+func sampleVerify() bool {
+	return verifyFraction > 0 && verifyRand.Float64() < verifyFraction
+}
+
+// verify runs the compiler for a cache hit and compares its output with the
+// cached one byte for byte, except for the parts that the kind of invocation
+// normalizes. A mismatch is recorded along with the key material.
+// The compiler's output is returned, since its files are the ones on disk. In
+// strict mode, a mismatch also fails the invocation.
+func (s *state) verify(cached cgowrap.Output) cgowrap.Output {
+	out := s.exec()
+
+	if err := out.ReadFiles(s.cache.outputs); err != nil {
+		logg.DebugFatalErr("cannot read output files:", err)
+		return out
+	}
+
+	diff := cgowrap.Normalize(s.kind, s.inv, cached).Diff(cgowrap.Normalize(s.kind, s.inv, out))
+	if len(diff) == 0 {
+		logg.Debug("verified cache hit")
+		return out
+	}
+
+	path, err := s.cache.RecordMismatch(cgowrap.NewMismatch(s.cache.cacheKey, s.inv, diff))
+	logg.DebugFatalErr("cannot record mismatch:", err)
+
+	msg := fmt.Sprintf("cached output differs from the compiler's in %s, recorded in %s",
+		strings.Join(diff, ", "), path)
+	logg.Debug(msg)
+
+	if verifyStrict {
+		out.Stderr = append(out.Stderr, "cgowrap: "+msg+"\n"...)
+		if out.Status == 0 {
+			out.Status = 1
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+func TestParseVerifyFraction(t *testing.T) {
+	tests := map[string]float64{
+		"":     0,
+		"0":    0,
+		"0.25": 0.25,
+		"1":    1,
+		"1.5":  0,
+		"-1":   0,
+		"all":  0,
+	}
+
+	for v, expect := range tests {
+		if f := parseVerifyFraction(v); f != expect {
+			t.Errorf("%q: expected %v, got %v", v, expect, f)
+		}
+	}
+}